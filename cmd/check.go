@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/types"
+	"github.com/cmseguin/monarch/internal/utils"
+	"github.com/ryanuber/go-glob"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+	checkCmd.Flags().StringP("dialect", "d", "", "SQL dialect to check the migrations against")
+	checkCmd.Flags().Bool("strict", false, "Exit with an error when a hint is found")
+}
+
+var checkCmd = &cobra.Command{
+	Use:   "check [limitPattern]",
+	Short: "Warn about migration statements that take heavy locks",
+	Run: utils.CreateCmdHandler(func(cmd *cobra.Command, args []string) *khata.Khata {
+		utils.LoadEnvFile(utils.GetStringArg(cmd, "dotenvfile", "", ""))
+
+		var limitPattern string = "*"
+
+		if len(args) > 0 {
+			limitPattern = args[0]
+		}
+
+		dialect := utils.GetDriverDialect(utils.GetStringArg(cmd, "dialect", "MONARCH_DRIVER", ""))
+
+		if dialect == "" {
+			return errors.FatalError.New("dialect is required")
+		}
+
+		if dialect != "postgres" && dialect != "cockroach" {
+			utils.PrintWarning(fmt.Sprintf("No lock hints available for dialect %s", dialect))
+			return nil
+		}
+
+		migrationDir, kErr := utils.GetMigrationPath("")
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration path")
+		}
+
+		migrationObjects := []types.MigrationObject{}
+
+		kErr = utils.GetUpMigratrionObjectsFromDir(migrationDir, &migrationObjects)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration objects")
+		}
+
+		hints := []types.LockHint{}
+
+		for _, migrationObject := range utils.SortMigrationObjects(migrationObjects) {
+			if !glob.Glob(limitPattern, migrationObject.Key) {
+				continue
+			}
+
+			fileContent, kErr := utils.GetMigrationContent(migrationDir, migrationObject.File)
+
+			if kErr != nil {
+				return kErr.Explainf("Error getting migration content: %s", migrationObject.File)
+			}
+
+			hints = append(hints, utils.CheckMigrationLocks(dialect, migrationObject.Key, fileContent)...)
+		}
+
+		if len(hints) == 0 {
+			utils.PrintSuccess("No statements taking heavy locks were found")
+			return nil
+		}
+
+		for _, hint := range hints {
+			utils.PrintWarning(fmt.Sprintf("%s: %s lock", hint.Key, hint.Lock))
+			utils.PrintStmt("  " + hint.Statement)
+			utils.PrintUnorderedList([]string{hint.Message, "Suggestion: " + hint.Suggestion})
+		}
+
+		if utils.GetBoolArg(cmd, "strict", "MONARCH_CHECK_STRICT", false) {
+			return errors.FatalError.New(fmt.Sprintf("%d statements take heavy locks", len(hints)))
+		}
+
+		return nil
+	}),
+}
@@ -3,16 +3,31 @@ package cmd
 import (
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/cmseguin/khata"
 	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/types"
 	"github.com/cmseguin/monarch/internal/utils"
 	"github.com/spf13/cobra"
 )
 
 func init() {
 	rootCmd.AddCommand(createCmd)
+	createCmd.Flags().String("versioning", "", "Versioning scheme of the migration: timestamp, utc, sequential or semantic")
+	createCmd.Flags().String("version", "", "Explicit version for the semantic versioning scheme (e.g. 1.2)")
+	createCmd.Flags().StringP("template", "t", "", "Template to generate the migration from")
+	createCmd.Flags().StringP("dialect", "d", "", "SQL dialect of the generated migration")
+	createCmd.Flags().String("table", "", "Table name passed to the template")
+	createCmd.Flags().String("column", "", "Column name passed to the template")
+	createCmd.Flags().String("type", "", "Column type passed to the template")
+	createCmd.Flags().String("columns", "", "Comma separated column names passed to the template")
+	createCmd.Flags().StringArray("arg", []string{}, "Extra template argument as key=value")
+	createCmd.Flags().String("from-schema", "", "Desired-state schema file to generate the migration from")
+	createCmd.Flags().String("scratch-driver", "", "Driver of the scratch database the schemas are built on (default sqlite)")
+	createCmd.Flags().String("scratch-connection", "", "Connection string of the scratch database (default in-memory sqlite)")
+	createCmd.Flags().StringArray("var", []string{}, "Template variable for the migrations as key=value")
 }
 
 var createCmd = &cobra.Command{
@@ -31,24 +46,106 @@ var createCmd = &cobra.Command{
 			return errors.FatalError.New("migration name is required")
 		}
 
-		// Get a timestamp for the migration
-		datestamp := time.Now().Format("20060102150405")
-
 		// validate the migration name
 		if !utils.ValidateMigrationName(migrationName) {
 			return errors.FatalError.New("invalid migration name")
 		}
 
-		// Create the migration file
-		migrationUpFile := datestamp + "-" + migrationName + ".up.sql"
-		migrationDownFile := datestamp + "-" + migrationName + ".down.sql"
-
 		migrationPath, kErr := utils.GetMigrationPath("")
 
 		if kErr != nil {
 			return kErr.Explain("Error getting migration path")
 		}
 
+		config, kErr := utils.LoadConfig()
+
+		if kErr != nil {
+			return kErr.Explain("Error loading config")
+		}
+
+		// Get the version prefix of the migration from the existing ones
+		existingMigrationObjects := []types.MigrationObject{}
+
+		kErr = utils.GetUpMigratrionObjectsFromDir(migrationPath, &existingMigrationObjects)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration objects")
+		}
+
+		existingMigrationKeys := []string{}
+		for _, migrationObject := range existingMigrationObjects {
+			existingMigrationKeys = append(existingMigrationKeys, migrationObject.Key)
+		}
+
+		versionPrefix, kErr := utils.GetNextMigrationPrefix(
+			utils.GetVersioningScheme(config, utils.GetStringArg(cmd, "versioning", "", "")),
+			existingMigrationKeys,
+			time.Now(),
+			utils.GetStringArg(cmd, "version", "", ""),
+		)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration version")
+		}
+
+		// Create the migration file
+		migrationUpFile := versionPrefix + migrationName + ".up.sql"
+		migrationDownFile := versionPrefix + migrationName + ".down.sql"
+
+		// Render the bodies of the migration from the template if one is given
+		var migrationUpContent, migrationDownContent string
+
+		if templateName := utils.GetStringArg(cmd, "template", "", ""); templateName != "" {
+			data := types.CreateTemplateData{
+				Name:    migrationName,
+				Dialect: utils.GetDriverDialect(utils.GetStringArg(cmd, "dialect", "MONARCH_DRIVER", "")),
+				Table:   utils.GetStringArg(cmd, "table", "", ""),
+				Column:  utils.GetStringArg(cmd, "column", "", ""),
+				Type:    utils.GetStringArg(cmd, "type", "", ""),
+				Columns: utils.GetListArg(cmd, "columns", ""),
+				Args:    map[string]string{},
+			}
+
+			if len(data.Columns) == 0 && data.Column != "" {
+				data.Columns = []string{data.Column}
+			}
+
+			templateArgs, _ := cmd.Flags().GetStringArray("arg")
+
+			for _, templateArg := range templateArgs {
+				key, value, found := strings.Cut(templateArg, "=")
+
+				if !found || key == "" {
+					return errors.FatalError.New("invalid template argument, expected key=value: " + templateArg)
+				}
+
+				data.Args[key] = value
+			}
+
+			migrationUpContent, migrationDownContent, kErr = utils.RenderCreateTemplate(migrationPath, templateName, data)
+
+			if kErr != nil {
+				return kErr.Explain("Error rendering migration template")
+			}
+		}
+
+		// Generate the bodies of the migration from the desired-state schema
+		if schemaFile := utils.GetStringArg(cmd, "from-schema", "", ""); schemaFile != "" {
+			if utils.GetStringArg(cmd, "template", "", "") != "" {
+				return errors.FatalError.New("--from-schema and --template cannot be used together")
+			}
+
+			migrationUpContent, migrationDownContent, kErr = generateMigrationFromSchema(cmd, migrationPath, existingMigrationObjects, schemaFile)
+
+			if kErr != nil {
+				return kErr.Explain("Error generating migration from schema")
+			}
+
+			if migrationUpContent == "" {
+				return errors.WarningError.New("The migrations already match " + schemaFile)
+			}
+		}
+
 		migrationUpPath := path.Join(migrationPath, migrationUpFile)
 		migrationDownPath := path.Join(migrationPath, migrationDownFile)
 
@@ -57,7 +154,7 @@ var createCmd = &cobra.Command{
 		if err == nil {
 			utils.PrintWarning("Migration up file already exists")
 		} else if os.IsNotExist(err) {
-			_, err := os.Create(migrationUpPath)
+			err := os.WriteFile(migrationUpPath, []byte(migrationUpContent), 0644)
 			if err != nil {
 				return errors.FatalError.New("error creating migration up file")
 			}
@@ -68,7 +165,7 @@ var createCmd = &cobra.Command{
 		if err == nil {
 			utils.PrintWarning("Migration down file already exists")
 		} else if os.IsNotExist(err) {
-			_, err := os.Create(migrationDownPath)
+			err := os.WriteFile(migrationDownPath, []byte(migrationDownContent), 0644)
 
 			if err != nil {
 				return errors.FatalError.New("error creating migration down file")
@@ -79,3 +176,83 @@ var createCmd = &cobra.Command{
 		return nil
 	}),
 }
+
+func generateMigrationFromSchema(
+	cmd *cobra.Command,
+	migrationDir string,
+	migrationObjects []types.MigrationObject,
+	schemaFile string,
+) (string, string, *khata.Khata) {
+	schemaContent, err := os.ReadFile(schemaFile)
+
+	if err != nil {
+		return "", "", khata.Wrap(err).Explainf("Error reading schema file %s", schemaFile)
+	}
+
+	vars, kErr := utils.GetTemplateVars(cmd)
+
+	if kErr != nil {
+		return "", "", kErr.Explain("Error getting template variables")
+	}
+
+	repeatableMigrationObjects := []types.MigrationObject{}
+
+	kErr = utils.GetRepeatableMigrationObjectsFromDir(migrationDir, vars, &repeatableMigrationObjects)
+
+	if kErr != nil {
+		return "", "", kErr.Explain("Error getting repeatable migration objects")
+	}
+
+	scratchDb, kErr := utils.OpenScratchDatabaseArg(cmd)
+
+	if kErr != nil {
+		return "", "", kErr.Explain("Error connecting to the scratch database")
+	}
+
+	defer scratchDb.Close()
+
+	ctx, cancel := utils.GetRunContext(cmd)
+	defer cancel()
+
+	// Build the schema produced by the current migrations
+	kErr = utils.ApplyMigrationsToDatabase(
+		ctx,
+		scratchDb,
+		migrationDir,
+		append(utils.SortMigrationObjects(migrationObjects), utils.SortMigrationObjects(repeatableMigrationObjects)...),
+		vars,
+	)
+
+	if kErr != nil {
+		return "", "", kErr.Explain("Error replaying migrations on the scratch database")
+	}
+
+	currentSchema, kErr := utils.IntrospectSchema(scratchDb)
+
+	if kErr != nil {
+		return "", "", kErr.Explain("Error introspecting the scratch database")
+	}
+
+	// Then build the desired schema on the same scratch database
+	kErr = utils.ResetScratchDatabase(scratchDb)
+
+	if kErr != nil {
+		return "", "", kErr.Explain("Error resetting the scratch database")
+	}
+
+	kErr = utils.ExecuteMigration(ctx, scratchDb, string(schemaContent))
+
+	if kErr != nil {
+		return "", "", kErr.Explainf("Error running schema file %s", schemaFile)
+	}
+
+	desiredSchema, kErr := utils.IntrospectSchema(scratchDb)
+
+	if kErr != nil {
+		return "", "", kErr.Explain("Error introspecting the scratch database")
+	}
+
+	up, down := utils.GenerateMigrationFromSchemas(currentSchema, desiredSchema)
+
+	return up, down, nil
+}
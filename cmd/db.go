@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbCreateCmd)
+	dbCmd.AddCommand(dbDropCmd)
+	dbCreateCmd.Flags().String("env", "", "Environment whose database is created")
+	dbCreateCmd.Flags().String("encoding", "", "Encoding of the database, the character set for MySQL")
+	dbCreateCmd.Flags().String("collation", "", "Collation of the database")
+	dbDropCmd.Flags().String("env", "", "Environment whose database is dropped")
+	dbDropCmd.Flags().String("confirm", "", "Name of the database, to drop it without typing it")
+}
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Create or drop the database itself",
+}
+
+var dbCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create the database if it does not exist",
+	Run: utils.CreateCmdHandler(func(cmd *cobra.Command, args []string) *khata.Khata {
+		utils.LoadEnvFile(utils.GetStringArg(cmd, "dotenvfile", "", ""))
+
+		driver, connection, _, kErr := utils.GetDatabaseConnection(cmd)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting the database connection")
+		}
+
+		name, kErr := utils.GetDatabaseName(driver, connection)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting the database name")
+		}
+
+		encoding := utils.GetStringArg(cmd, "encoding", "", "")
+		collation := utils.GetStringArg(cmd, "collation", "", "")
+
+		if driver == "sqlite" {
+			if encoding != "" || collation != "" {
+				return errors.FatalError.New("--encoding and --collation are not supported by sqlite")
+			}
+
+			created, kErr := utils.CreateSQLiteDatabase(name)
+
+			if kErr != nil {
+				return kErr
+			}
+
+			if !created {
+				utils.PrintWarning(fmt.Sprintf("Database %s already exists", name))
+				return nil
+			}
+
+			utils.PrintSuccess(fmt.Sprintf("Database %s created", name))
+			return nil
+		}
+
+		db, kErr := connectToMaintenanceDatabase(cmd, driver, connection)
+
+		if kErr != nil {
+			return kErr
+		}
+
+		defer db.Close()
+
+		exists, kErr := utils.DatabaseExists(db, name)
+
+		if kErr != nil {
+			return kErr
+		}
+
+		if exists {
+			utils.PrintWarning(fmt.Sprintf("Database %s already exists", name))
+			return nil
+		}
+
+		kErr = utils.CreateDatabase(db, name, encoding, collation)
+
+		if kErr != nil {
+			return kErr
+		}
+
+		utils.PrintSuccess(fmt.Sprintf("Database %s created", name))
+		return nil
+	}),
+}
+
+var dbDropCmd = &cobra.Command{
+	Use:   "drop",
+	Short: "Drop the database and all its data",
+	Run: utils.CreateCmdHandler(func(cmd *cobra.Command, args []string) *khata.Khata {
+		utils.LoadEnvFile(utils.GetStringArg(cmd, "dotenvfile", "", ""))
+
+		driver, connection, envConfig, kErr := utils.GetDatabaseConnection(cmd)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting the database connection")
+		}
+
+		// A protected environment never has its database dropped, the flag
+		// has to be removed from the config first
+		if envConfig.Protected {
+			return errors.FatalError.New(fmt.Sprintf(
+				"environment %s is protected, remove \"protected\" from its config to drop its database",
+				utils.GetEnvironmentArg(cmd),
+			))
+		}
+
+		name, kErr := utils.GetDatabaseName(driver, connection)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting the database name")
+		}
+
+		// Dropping a database cannot be undone, its name has to be typed
+		confirmation := utils.GetStringArg(cmd, "confirm", "", "")
+
+		if confirmation == "" {
+			utils.PrintWarning(fmt.Sprintf("Database %s and all its data will be permanently deleted", name))
+			confirmation = utils.AskForInput(fmt.Sprintf("Type the name of the database to confirm (%s):", name))
+		}
+
+		if confirmation != name {
+			utils.PrintWarning("The name does not match, aborting database drop")
+			return nil
+		}
+
+		if driver == "sqlite" {
+			dropped, kErr := utils.DropSQLiteDatabase(name)
+
+			if kErr != nil {
+				return kErr
+			}
+
+			if !dropped {
+				utils.PrintWarning(fmt.Sprintf("Database %s does not exist", name))
+				return nil
+			}
+
+			utils.PrintSuccess(fmt.Sprintf("Database %s dropped", name))
+			return nil
+		}
+
+		db, kErr := connectToMaintenanceDatabase(cmd, driver, connection)
+
+		if kErr != nil {
+			return kErr
+		}
+
+		defer db.Close()
+
+		exists, kErr := utils.DatabaseExists(db, name)
+
+		if kErr != nil {
+			return kErr
+		}
+
+		if !exists {
+			utils.PrintWarning(fmt.Sprintf("Database %s does not exist", name))
+			return nil
+		}
+
+		kErr = utils.DropDatabase(db, name)
+
+		if kErr != nil {
+			return kErr
+		}
+
+		utils.PrintSuccess(fmt.Sprintf("Database %s dropped", name))
+		return nil
+	}),
+}
+
+func connectToMaintenanceDatabase(cmd *cobra.Command, driver string, connection string) (*sql.DB, *khata.Khata) {
+	maintenanceConnection, kErr := utils.GetMaintenanceConnection(driver, connection)
+
+	if kErr != nil {
+		return nil, kErr.Explain("Error getting the maintenance connection")
+	}
+
+	db, kErr := utils.WaitForDatabase(
+		driver,
+		maintenanceConnection,
+		nil,
+		utils.GetDurationArg(cmd, "wait-for-db", "MONARCH_WAIT_FOR_DB", 0),
+	)
+
+	if kErr != nil {
+		return nil, kErr.Explain("Error connecting to the server")
+	}
+
+	return db, nil
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/types"
+	"github.com/cmseguin/monarch/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().String("env", "", "Environment whose database is compared with the migrations")
+	diffCmd.Flags().String("format", "text", "Output format: text or json")
+	diffCmd.Flags().String("scratch-driver", "", "Driver of the scratch database the migrations are replayed against, of the same dialect as the database (default sqlite)")
+	diffCmd.Flags().String("scratch-connection", "", "Connection string of the scratch database (default in-memory sqlite)")
+	diffCmd.Flags().StringArray("var", []string{}, "Template variable for the migrations as key=value")
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare the schema built by the applied migrations with the live database",
+	Run: utils.CreateCmdHandler(func(cmd *cobra.Command, args []string) *khata.Khata {
+		utils.LoadEnvFile(utils.GetStringArg(cmd, "dotenvfile", "", ""))
+
+		format := utils.GetStringArg(cmd, "format", "", "text")
+
+		if format != "text" && format != "json" {
+			return errors.FatalError.New("unsupported format: " + format)
+		}
+
+		migrationDir, kErr := utils.GetMigrationPath("")
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration path")
+		}
+
+		vars, kErr := utils.GetTemplateVars(cmd)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting template variables")
+		}
+
+		migrationObjects := []types.MigrationObject{}
+
+		kErr = utils.GetUpMigratrionObjectsFromDir(migrationDir, &migrationObjects)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration objects")
+		}
+
+		kErr = utils.GetRepeatableMigrationObjectsFromDir(migrationDir, vars, &migrationObjects)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting repeatable migration objects")
+		}
+
+		db, kErr := utils.InitDb(cmd)
+
+		if kErr != nil {
+			return kErr.Explain("Error connecting to the database")
+		}
+
+		appliedMigrationKeys, kErr := utils.GetMigrationsFromDatabase(db, true)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migrations from database")
+		}
+
+		// Replay what the database applied, repeatables after the versioned ones
+		appliedMigrationObjects := []types.MigrationObject{}
+		appliedRepeatableObjects := []types.MigrationObject{}
+
+		for _, migrationObject := range utils.SortMigrationObjects(migrationObjects) {
+			isApplied := utils.FindIndexInString(appliedMigrationKeys, func(key string, _ int) bool {
+				return key == migrationObject.Key
+			}) != -1
+
+			if !isApplied {
+				isSatisfied, kErr := utils.IsBaselineSatisfied(migrationObject, appliedMigrationKeys)
+
+				if kErr != nil {
+					return kErr.Explain("Error checking squashed migrations")
+				}
+
+				if !isSatisfied {
+					continue
+				}
+			}
+
+			if migrationObject.Checksum != "" {
+				appliedRepeatableObjects = append(appliedRepeatableObjects, migrationObject)
+			} else {
+				appliedMigrationObjects = append(appliedMigrationObjects, migrationObject)
+			}
+		}
+
+		scratchDb, kErr := utils.OpenScratchDatabaseArg(cmd)
+
+		if kErr != nil {
+			return kErr.Explain("Error connecting to the scratch database")
+		}
+
+		defer scratchDb.Close()
+
+		// Introspection differs between dialects in how it renders foreign keys,
+		// checks and implicit indexes, only a scratch database of the same
+		// dialect gives a meaningful diff
+		if utils.GetDialect(scratchDb) != utils.GetDialect(db) {
+			return errors.FatalError.New(fmt.Sprintf(
+				"the scratch database is %s and the database is %s, pass --scratch-driver and --scratch-connection of a disposable %s database",
+				utils.GetDialect(scratchDb),
+				utils.GetDialect(db),
+				utils.GetDialect(db),
+			))
+		}
+
+		ctx, cancel := utils.GetRunContext(cmd)
+		defer cancel()
+
+		kErr = utils.ApplyMigrationsToDatabase(ctx, scratchDb, migrationDir, append(appliedMigrationObjects, appliedRepeatableObjects...), vars)
+
+		if kErr != nil {
+			return kErr.Explain("Error replaying migrations on the scratch database")
+		}
+
+		expectedSchema, kErr := utils.IntrospectSchema(scratchDb)
+
+		if kErr != nil {
+			return kErr.Explain("Error introspecting the scratch database")
+		}
+
+		actualSchema, kErr := utils.IntrospectSchema(db)
+
+		if kErr != nil {
+			return kErr.Explain("Error introspecting the database")
+		}
+
+		diff := utils.DiffSchemas(expectedSchema, actualSchema)
+
+		if format == "json" {
+			output, err := json.MarshalIndent(diff, "", "  ")
+
+			if err != nil {
+				return khata.Wrap(err).Explain("Error encoding the schema diff")
+			}
+
+			utils.PrintStmt(string(output))
+		} else if !utils.IsSchemaDiffEmpty(diff) {
+			utils.PrintSchemaDiff(diff)
+		}
+
+		if !utils.IsSchemaDiffEmpty(diff) {
+			return errors.FatalError.New("the database schema differs from the migrations")
+		}
+
+		if format == "text" {
+			utils.PrintSuccess("The database schema matches the migrations")
+		}
+
+		return nil
+	}),
+}
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/cmseguin/khata"
 	"github.com/cmseguin/monarch/internal/errors"
 	"github.com/cmseguin/monarch/internal/types"
@@ -10,6 +12,16 @@ import (
 
 func init() {
 	rootCmd.AddCommand(downCmd)
+	downCmd.Flags().String("env", "", "Environment to run the migrations for")
+	downCmd.Flags().String("tags", "", "Only run the migrations with one of these comma separated tags")
+	downCmd.Flags().String("exclude-tags", "", "Skip the migrations with one of these comma separated tags")
+	downCmd.Flags().StringArray("var", []string{}, "Template variable for the migrations as key=value")
+	downCmd.Flags().Int("batch", 0, "Roll back the migrations of this batch instead of the last one")
+	downCmd.Flags().Bool("all", false, "Roll back every applied migration")
+	downCmd.Flags().Bool("dump-schema", false, "Dump the schema to schema.sql after the migrations ran")
+	downCmd.Flags().Bool("dry-run", false, "Print the rendered migrations without running them")
+	downCmd.Flags().Duration("migration-timeout", 0, "Timeout of each migration without a timeout directive")
+	downCmd.Flags().Int("retries", 0, "Times a migration failing with a transient error is retried when it ran in a transaction")
 }
 
 var downCmd = &cobra.Command{
@@ -53,29 +65,161 @@ var downCmd = &cobra.Command{
 			return kErr.Explain("Error connecting to the database")
 		}
 
-		// Get the list of migrations that have already been run
-		invalidMigrationKeysFromDatabase, kErr := utils.GetMigrationsFromDatabase(db, false)
+		kErr = utils.UpgradeMigrationTable(db)
 
 		if kErr != nil {
-			return kErr.Explain("Error getting migrations from database")
+			return kErr.Explain("Error upgrading the migrations table")
+		}
+
+		migrationsFromDb, kErr := utils.GetAllMigrationsFromDatabase(db)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting all migrations from database")
+		}
+
+		// Refuse to migrate a database left halfway through a migration
+		kErr = utils.CheckDirtyMigrations(migrationsFromDb)
+
+		if kErr != nil {
+			return kErr
+		}
+
+		migrationsFromDbMap := map[string]types.Migration{}
+		for _, migration := range migrationsFromDb {
+			migrationsFromDbMap[migration.Key] = migration
+		}
+
+		all := utils.GetBoolArg(cmd, "all", "", false)
+		batch := utils.GetIntArg(cmd, "batch", "", 0)
+
+		if all && batch != 0 {
+			return errors.FatalError.New("--all and --batch cannot be used together")
+		}
+
+		// Without --batch or --all only the last batch is rolled back.
+		// Repeatables and baselines have no down migration, the batch of a run
+		// that only re-applied them is skipped.
+		if !all && !cmd.Flags().Changed("batch") {
+			downMigrationKeys := map[string]bool{}
+			for _, migrationObject := range migrationObjects {
+				downMigrationKeys[migrationObject.Key] = true
+			}
+
+			hasLegacyMigrations := false
+
+			for _, migration := range migrationsFromDb {
+				if !migration.IsApplied || !downMigrationKeys[migration.Key] {
+					continue
+				}
+
+				hasLegacyMigrations = hasLegacyMigrations || migration.Batch == 0
+
+				if migration.Batch > batch {
+					batch = migration.Batch
+				}
+			}
+
+			// Batch 0 holds every migration applied before batches were
+			// recorded, it is only rolled back when asked for explicitly
+			if batch == 0 && hasLegacyMigrations {
+				return errors.FatalError.New("the applied migrations have no batch, use --batch 0 or --all to roll all of them back")
+			}
+		}
+
+		// Only the migrations recorded as applied can be rolled back
+		rollbackableMigrationKeys := map[string]bool{}
+		for _, migration := range migrationsFromDb {
+			if migration.IsApplied && (all || migration.Batch == batch) {
+				rollbackableMigrationKeys[migration.Key] = true
+			}
 		}
 
 		sortedMigrations := utils.SortMigrationObjects(migrationObjects)
 		sortedMigrations = utils.ReverseMigrationObjects(sortedMigrations)
 
-		// Filter out the migrations that have already been run
-		migrationObjectsToRun := utils.FilterMigrationToRun(
-			limitPattern,
-			sortedMigrations,
-			invalidMigrationKeysFromDatabase,
-		)
+		migrationObjectsToRun := []types.MigrationObject{}
+		for _, migrationObject := range utils.FilterMigrationToRun(limitPattern, sortedMigrations, []string{}) {
+			if rollbackableMigrationKeys[migrationObject.Key] {
+				migrationObjectsToRun = append(migrationObjectsToRun, migrationObject)
+			}
+		}
+
+		// Skip the migrations that are not selected by the filter
+		migrationObjectsToRun, _ = utils.SplitMigrationsByFilter(migrationObjectsToRun, utils.GetMigrationFilterArg(cmd))
 
 		if len(migrationObjectsToRun) == 0 {
 			return errors.WarningError.New("No applied migration migrations to rollback after filtering")
 		}
 
+		var rollbackMigrationKeys []string = []string{}
+		for _, migrationObject := range migrationObjectsToRun {
+			if migrationObject.Directives.Irreversible {
+				return errors.FatalError.New("migration " + migrationObject.Key + " is irreversible")
+			}
+
+			rollbackMigrationKeys = append(rollbackMigrationKeys, migrationObject.Key)
+		}
+
+		// Make sure no remaining migration depends on the ones rolled back
+		upMigrationObjects := []types.MigrationObject{}
+
+		kErr = utils.GetUpMigratrionObjectsFromDir(migrationDir, &upMigrationObjects)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration objects")
+		}
+
+		appliedMigrationKeys, kErr := utils.GetMigrationsFromDatabase(db, true)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migrations from database")
+		}
+
+		kErr = utils.CheckRollbackDependents(
+			upMigrationObjects,
+			appliedMigrationKeys,
+			utils.AddSquashedMigrationKeys(upMigrationObjects, rollbackMigrationKeys),
+		)
+
+		if kErr != nil {
+			return kErr.Explain("Error checking migration requirements")
+		}
+
+		// Render every migration up front so an undefined variable fails early
+		vars, kErr := utils.GetTemplateVars(cmd)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting template variables")
+		}
+
+		renderedMigrations := map[string]string{}
+
+		for _, migrationObject := range migrationObjectsToRun {
+			fileContent, kErr := utils.GetRenderedMigrationContent(migrationDir, migrationObject.File, vars)
+
+			if kErr != nil {
+				return kErr.Explainf("Error getting migration content: %s", migrationObject.File)
+			}
+
+			renderedMigrations[migrationObject.Key] = fileContent
+		}
+
+		if utils.GetBoolArg(cmd, "dry-run", "", false) {
+			for _, migrationObject := range migrationObjectsToRun {
+				utils.PrintInfo("-- " + migrationObject.File)
+				utils.PrintStmt(renderedMigrations[migrationObject.Key])
+			}
+
+			utils.PrintWarning("Dry run, no migration was rollback")
+			return nil
+		}
+
 		// Print the migrations that are going to be rollback
-		utils.PrintStmt("The following migration will be rollback:")
+		if all {
+			utils.PrintStmt("The following migration will be rollback:")
+		} else {
+			utils.PrintStmt(fmt.Sprintf("The following migration of batch %d will be rollback:", batch))
+		}
 
 		var migrationKeys []string = []string{}
 		for _, migrationObject := range migrationObjectsToRun {
@@ -90,26 +234,47 @@ var downCmd = &cobra.Command{
 			return nil
 		}
 
+		ctx, cancel := utils.GetRunContext(cmd)
+		defer cancel()
+
+		// Keep other monarch processes from migrating the database meanwhile
+		release, kErr := utils.LockMigrations(ctx, db, appliedMigrationKeys)
+
+		if kErr != nil {
+			return kErr
+		}
+
+		defer release()
+
+		migrationObjectsToRun = utils.SetDefaultMigrationDirectives(cmd, migrationObjectsToRun)
+
 		// Run the migrations
 		for _, migrationObject := range migrationObjectsToRun {
-			fileContent, kErr := utils.GetMigrationContent(migrationDir, migrationObject.File)
+			kErr = utils.RunTrackedMigration(ctx, db, migrationObject, renderedMigrations[migrationObject.Key], migrationsFromDbMap[migrationObject.Key])
 
 			if kErr != nil {
-				return kErr.Explainf("Error getting migration content: %s", migrationObject.File)
+				return kErr
 			}
 
-			// Run the migration
-			kErr = utils.ExecuteMigration(db, fileContent)
+			// Update the status of the migration in the database.
+			kErr = utils.RollbackMigration(db, migrationObject.Key)
 
 			if kErr != nil {
-				return kErr.Explainf("Error running migration: %s", migrationObject.File)
+				return kErr.Explainf("Error updating the status of migration: %s", migrationObject.Key)
 			}
+		}
 
-			// Update the status of the migration in the database.
-			kErr = utils.RollbackMigration(db, migrationObject.Key)
+		if utils.GetBoolArg(cmd, "dump-schema", "MONARCH_DUMP_SCHEMA", false) {
+			dumpPath, kErr := utils.GetSchemaDumpPath(cmd)
 
 			if kErr != nil {
-				return kErr.Explainf("Error updating the status of migration: %s", migrationObject.Key)
+				return kErr.Explain("Error getting schema dump path")
+			}
+
+			kErr = utils.DumpSchema(db, dumpPath)
+
+			if kErr != nil {
+				return kErr.Explain("Error dumping schema")
 			}
 		}
 
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(dumpCmd)
+	dumpCmd.Flags().StringP("output", "o", "", "File the schema is dumped to (default schema.sql)")
+	dumpCmd.Flags().Bool("check", false, "Fail when the dump on disk does not match the database instead of writing it")
+	dumpCmd.Flags().String("env", "", "Environment whose database is dumped")
+}
+
+var dumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "Dump the schema of the database to a file",
+	Run: utils.CreateCmdHandler(func(cmd *cobra.Command, args []string) *khata.Khata {
+		utils.LoadEnvFile(utils.GetStringArg(cmd, "dotenvfile", "", ""))
+
+		dumpPath, kErr := utils.GetSchemaDumpPath(cmd)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting schema dump path")
+		}
+
+		db, kErr := utils.InitDb(cmd)
+
+		if kErr != nil {
+			return kErr.Explain("Error connecting to the database")
+		}
+
+		if utils.GetBoolArg(cmd, "check", "", false) {
+			stale, kErr := utils.IsSchemaDumpStale(db, dumpPath)
+
+			if kErr != nil {
+				return kErr.Explain("Error checking schema dump")
+			}
+
+			if stale {
+				return errors.FatalError.New(dumpPath + " is stale, run `monarch dump` and commit the result")
+			}
+
+			utils.PrintSuccess(dumpPath + " is up to date")
+			return nil
+		}
+
+		kErr = utils.DumpSchema(db, dumpPath)
+
+		if kErr != nil {
+			return kErr.Explain("Error dumping schema")
+		}
+
+		utils.PrintSuccess("Schema dumped to " + dumpPath)
+		return nil
+	}),
+}
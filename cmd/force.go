@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/types"
+	"github.com/cmseguin/monarch/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(forceCmd)
+	forceCmd.Flags().String("state", "", "State to record for the migration (applied or pending)")
+}
+
+var forceCmd = &cobra.Command{
+	Use:   "force <key> --state applied|pending",
+	Short: "Record the state of a migration after fixing the database by hand",
+	Run: utils.CreateCmdHandler(func(cmd *cobra.Command, args []string) *khata.Khata {
+		utils.LoadEnvFile(utils.GetStringArg(cmd, "dotenvfile", "", ""))
+
+		if len(args) == 0 {
+			return errors.FatalError.New("migration key is required")
+		}
+
+		key := args[0]
+		state := utils.GetStringArg(cmd, "state", "", "")
+
+		if state != types.MigrationStateApplied && state != types.MigrationStatePending {
+			return errors.FatalError.New("--state must be applied or pending")
+		}
+
+		db, kErr := utils.InitDb(cmd)
+
+		if kErr != nil {
+			return kErr.Explain("Error connecting to the database")
+		}
+
+		kErr = utils.UpgradeMigrationTable(db)
+
+		if kErr != nil {
+			return kErr.Explain("Error upgrading the migrations table")
+		}
+
+		migrationsFromDb, kErr := utils.GetAllMigrationsFromDatabase(db)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting all migrations from database")
+		}
+
+		migration := types.Migration{}
+		for _, m := range migrationsFromDb {
+			if m.Key == key {
+				migration = m
+			}
+		}
+
+		// Without a row the key must at least name a migration file
+		if migration.Key != key {
+			migrationDir, kErr := utils.GetMigrationPath("")
+
+			if kErr != nil {
+				return kErr.Explain("Error getting migration path")
+			}
+
+			migrationObjects := []types.MigrationObject{}
+
+			kErr = utils.GetUpMigratrionObjectsFromDir(migrationDir, &migrationObjects)
+
+			if kErr != nil {
+				return kErr.Explain("Error getting migration objects")
+			}
+
+			vars, kErr := utils.GetTemplateVars(cmd)
+
+			if kErr != nil {
+				return kErr.Explain("Error getting template variables")
+			}
+
+			kErr = utils.GetRepeatableMigrationObjectsFromDir(migrationDir, vars, &migrationObjects)
+
+			if kErr != nil {
+				return kErr.Explain("Error getting repeatable migration objects")
+			}
+
+			found := false
+			for _, migrationObject := range migrationObjects {
+				found = found || migrationObject.Key == key
+			}
+
+			if !found {
+				return errors.FatalError.New("migration not found: " + key)
+			}
+		}
+
+		currentState := migration.State
+
+		if currentState == "" {
+			currentState = "untracked"
+		}
+
+		utils.PrintStmt(fmt.Sprintf("Migration %s will be forced from %s to %s", key, currentState, state))
+		res := utils.AskForConfirmation("Continue?", "n")
+
+		if !res {
+			return errors.WarningError.New("Aborting force")
+		}
+
+		if migration.Key != key {
+			kErr = utils.CreateMigrationEntry(db, key)
+
+			if kErr != nil {
+				return kErr.Explainf("Error creating migration entry: %s", key)
+			}
+		}
+
+		if state == types.MigrationStatePending {
+			kErr = utils.RollbackMigration(db, key)
+		} else {
+			batch := migration.Batch
+
+			if batch == 0 {
+				batch, kErr = utils.GetNextMigrationBatch(db)
+
+				if kErr != nil {
+					return kErr.Explain("Error getting the migration batch")
+				}
+			}
+
+			kErr = utils.ApplyMigration(db, key, migration.Filter, batch)
+		}
+
+		if kErr != nil {
+			return kErr.Explainf("Error updating the status of migration: %s", key)
+		}
+
+		utils.PrintSuccess(fmt.Sprintf("Migration %s forced to %s", key, state))
+		return nil
+	}),
+}
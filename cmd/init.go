@@ -44,6 +44,13 @@ var initCmd = &cobra.Command{
 			return kErr.Explain("Error creating migration table")
 		}
 
+		// Create the seeds table
+		kErr = utils.CreateSeedTable(db)
+
+		if kErr != nil {
+			return kErr.Explain("Error creating seed table")
+		}
+
 		migrationDir := path.Join(currentDir, "migrations")
 
 		// check if the directory exists
@@ -56,6 +63,16 @@ var initCmd = &cobra.Command{
 			}
 		}
 
+		seedDir := path.Join(currentDir, "seeds")
+
+		if _, err := os.Stat(seedDir); os.IsNotExist(err) {
+			err := os.Mkdir(seedDir, 0755)
+
+			if err != nil {
+				return khata.Wrap(err).Explain("Error creating seeds directory")
+			}
+		}
+
 		utils.PrintSuccess(fmt.Sprintf("Initialized monarch in %s", currentDir))
 		return nil
 	}),
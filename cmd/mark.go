@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/types"
+	"github.com/cmseguin/monarch/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(markCmd)
+	markCmd.Flags().String("env", "", "Environment to mark the migrations for")
+	markCmd.Flags().String("tags", "", "Only mark the migrations with one of these comma separated tags")
+	markCmd.Flags().String("exclude-tags", "", "Skip the migrations with one of these comma separated tags")
+}
+
+var markCmd = &cobra.Command{
+	Use:   "mark [limitPattern]",
+	Short: "Record the pending migrations as applied without running them",
+	Run: utils.CreateCmdHandler(func(cmd *cobra.Command, args []string) *khata.Khata {
+		utils.LoadEnvFile(utils.GetStringArg(cmd, "dotenvfile", "", ""))
+
+		var limitPattern string = "*"
+
+		if len(args) > 0 {
+			limitPattern = args[0]
+		}
+
+		migrationDir, kErr := utils.GetMigrationPath("")
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration path")
+		}
+
+		migrationObjects := []types.MigrationObject{}
+
+		kErr = utils.GetUpMigratrionObjectsFromDir(migrationDir, &migrationObjects)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration objects")
+		}
+
+		db, kErr := utils.InitDb(cmd)
+
+		if kErr != nil {
+			return kErr.Explain("Error connecting to the database")
+		}
+
+		kErr = utils.UpgradeMigrationTable(db)
+
+		if kErr != nil {
+			return kErr.Explain("Error upgrading the migrations table")
+		}
+
+		migrationsFromDb, kErr := utils.GetAllMigrationsFromDatabase(db)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting all migrations from database")
+		}
+
+		kErr = utils.CheckDirtyMigrations(migrationsFromDb)
+
+		if kErr != nil {
+			return kErr
+		}
+
+		migrationsFromDbMap := map[string]types.Migration{}
+		appliedMigrationKeys := []string{}
+		for _, m := range migrationsFromDb {
+			migrationsFromDbMap[m.Key] = m
+
+			if m.IsApplied {
+				appliedMigrationKeys = append(appliedMigrationKeys, m.Key)
+			}
+		}
+
+		filter := utils.GetMigrationFilterArg(cmd)
+
+		migrationObjectsToMark, _ := utils.SplitMigrationsByFilter(
+			utils.FilterMigrationToRun(limitPattern, utils.SortMigrationObjects(migrationObjects), appliedMigrationKeys),
+			filter,
+		)
+
+		if len(migrationObjectsToMark) == 0 {
+			return errors.WarningError.New("No pending migrations to mark")
+		}
+
+		utils.PrintStmt("The following migrations will be recorded as applied without running:")
+
+		var migrationKeys []string = []string{}
+		for _, migrationObject := range migrationObjectsToMark {
+			migrationKeys = append(migrationKeys, migrationObject.Key)
+		}
+
+		utils.PrintOrderedList(migrationKeys)
+		res := utils.AskForConfirmation("Continue?", "n")
+
+		if !res {
+			return errors.WarningError.New("Aborting mark")
+		}
+
+		batch, kErr := utils.GetNextMigrationBatch(db)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting the migration batch")
+		}
+
+		for _, migrationObject := range migrationObjectsToMark {
+			if migrationsFromDbMap[migrationObject.Key].Key != migrationObject.Key {
+				kErr = utils.CreateMigrationEntry(db, migrationObject.Key)
+
+				if kErr != nil {
+					return kErr.Explainf("Error creating migration entry: %s", migrationObject.Key)
+				}
+			}
+
+			kErr = utils.ApplyMigration(db, migrationObject.Key, utils.FormatMigrationFilter(filter), batch)
+
+			if kErr != nil {
+				return kErr.Explainf("Error updating the status of migration: %s", migrationObject.Key)
+			}
+		}
+
+		utils.PrintSuccess("Migrations marked as applied")
+		return nil
+	}),
+}
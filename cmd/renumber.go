@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"time"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/types"
+	"github.com/cmseguin/monarch/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(renumberCmd)
+	renumberCmd.Flags().String("against", "", "Environment whose database the pending migrations are checked against")
+	renumberCmd.Flags().String("versioning", "", "Versioning scheme of the new versions: timestamp, utc, sequential or semantic")
+}
+
+var renumberCmd = &cobra.Command{
+	Use:   "renumber",
+	Short: "Give pending migrations older than the latest applied one a new version",
+	Run: utils.CreateCmdHandler(func(cmd *cobra.Command, args []string) *khata.Khata {
+		utils.LoadEnvFile(utils.GetStringArg(cmd, "dotenvfile", "", ""))
+
+		migrationDir, kErr := utils.GetMigrationPath("")
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration path")
+		}
+
+		config, kErr := utils.LoadConfig()
+
+		if kErr != nil {
+			return kErr.Explain("Error loading config")
+		}
+
+		migrationObjects := []types.MigrationObject{}
+
+		kErr = utils.GetUpMigratrionObjectsFromDir(migrationDir, &migrationObjects)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration objects")
+		}
+
+		db, kErr := utils.InitDb(cmd)
+
+		if kErr != nil {
+			return kErr.Explain("Error connecting to the database")
+		}
+
+		migrationsFromDb, kErr := utils.GetAllMigrationsFromDatabase(db)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting all migrations from database")
+		}
+
+		migrationsFromDbMap := map[string]types.Migration{}
+		appliedMigrationKeys := []string{}
+
+		for _, m := range migrationsFromDb {
+			migrationsFromDbMap[m.Key] = m
+
+			if m.IsApplied {
+				appliedMigrationKeys = append(appliedMigrationKeys, m.Key)
+			}
+		}
+
+		sortedMigrations := utils.SortMigrationObjects(migrationObjects)
+		pendingMigrationObjects := utils.FilterMigrationToRun("*", sortedMigrations, appliedMigrationKeys)
+		outOfOrderMigrationObjects := utils.FindOutOfOrderMigrations(pendingMigrationObjects, appliedMigrationKeys)
+
+		if len(outOfOrderMigrationObjects) == 0 {
+			utils.PrintSuccess("No pending migration is older than the latest applied one")
+			return nil
+		}
+
+		existingMigrationKeys := []string{}
+		for _, migrationObject := range migrationObjects {
+			existingMigrationKeys = append(existingMigrationKeys, migrationObject.Key)
+		}
+
+		scheme := utils.GetVersioningScheme(config, utils.GetStringArg(cmd, "versioning", "", ""))
+		now := time.Now()
+		renames := map[string]string{}
+		renameList := []string{}
+
+		for i, migrationObject := range outOfOrderMigrationObjects {
+			// A migration with a row in the database was released, renaming it
+			// would make it run a second time
+			if _, ok := migrationsFromDbMap[migrationObject.Key]; ok {
+				utils.PrintWarning("Skipping released migration " + migrationObject.Key)
+				continue
+			}
+
+			// Space the timestamps so every migration gets a distinct version
+			versionPrefix, kErr := utils.GetNextMigrationPrefix(scheme, existingMigrationKeys, now.Add(time.Duration(i)*time.Second), "")
+
+			if kErr != nil {
+				return kErr.Explain("Error getting migration version")
+			}
+
+			newKey := versionPrefix + utils.GetMigrationName(migrationObject.Key)
+			renames[migrationObject.Key] = newKey
+			renameList = append(renameList, migrationObject.Key+" -> "+newKey)
+			existingMigrationKeys = append(existingMigrationKeys, newKey)
+		}
+
+		if len(renames) == 0 {
+			return errors.WarningError.New("No unreleased migration to renumber")
+		}
+
+		utils.PrintStmt("The following migrations will be renumbered:")
+		utils.PrintOrderedList(renameList)
+
+		res := utils.AskForConfirmation("Continue?", "y")
+
+		if !res {
+			return errors.WarningError.New("Aborting renumbering")
+		}
+
+		for _, migrationObject := range outOfOrderMigrationObjects {
+			newKey, ok := renames[migrationObject.Key]
+
+			if !ok {
+				continue
+			}
+
+			for _, suffix := range []string{".up.sql", ".down.sql"} {
+				oldPath := path.Join(migrationDir, migrationObject.Key+suffix)
+
+				if _, err := os.Stat(oldPath); os.IsNotExist(err) {
+					continue
+				}
+
+				err := os.Rename(oldPath, path.Join(migrationDir, newKey+suffix))
+
+				if err != nil {
+					return khata.Wrap(err).Explainf("Error renaming migration %s", migrationObject.Key)
+				}
+			}
+		}
+
+		utils.PrintSuccess("Migrations renumbered successfully")
+		return nil
+	}),
+}
@@ -38,4 +38,6 @@ func Execute() {
 func init() {
 	rootCmd.Version = "0.0.8"
 	rootCmd.SetVersionTemplate("v{{.Version}}\n")
+	rootCmd.PersistentFlags().Duration("wait-for-db", 0, "How long to wait for the database to accept connections")
+	rootCmd.PersistentFlags().Duration("timeout", 0, "Maximum duration of the whole run, the running migration is rolled back when it expires")
 }
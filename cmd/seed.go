@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"time"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/types"
+	"github.com/cmseguin/monarch/internal/utils"
+	"github.com/ryanuber/go-glob"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(seedCmd)
+	seedCmd.AddCommand(seedCreateCmd)
+	seedCmd.Flags().String("env", "", "Environment to run the seeds for")
+	seedCmd.Flags().String("tags", "", "Only run the seeds with one of these comma separated tags")
+	seedCmd.Flags().String("exclude-tags", "", "Skip the seeds with one of these comma separated tags")
+	seedCmd.Flags().StringArray("var", []string{}, "Template variable for the seeds as key=value")
+	seedCreateCmd.Flags().String("versioning", "", "Versioning scheme of the seed: timestamp, utc, sequential or semantic")
+	seedCreateCmd.Flags().String("version", "", "Explicit version for the semantic versioning scheme (e.g. 1.2)")
+}
+
+var seedCmd = &cobra.Command{
+	Use:   "seed [limitPattern]",
+	Short: "Run the seeds that have not been run yet and the re-runnable ones",
+	Run: utils.CreateCmdHandler(func(cmd *cobra.Command, args []string) *khata.Khata {
+		utils.LoadEnvFile(utils.GetStringArg(cmd, "dotenvfile", "", ""))
+
+		var limitPattern string = "*"
+
+		if len(args) > 0 {
+			limitPattern = args[0]
+		}
+
+		seedDir, kErr := utils.GetSeedPath("")
+
+		if kErr != nil {
+			return kErr.Explain("Error getting seed path")
+		}
+
+		seedObjects := []types.MigrationObject{}
+
+		kErr = utils.GetSeedObjectsFromDir(seedDir, &seedObjects)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting seed objects")
+		}
+
+		if len(seedObjects) == 0 {
+			utils.PrintWarning("No seeds found")
+			return nil
+		}
+
+		db, kErr := utils.InitDb(cmd)
+
+		if kErr != nil {
+			return kErr.Explain("Error connecting to the database")
+		}
+
+		kErr = utils.CreateSeedTable(db)
+
+		if kErr != nil {
+			return kErr.Explain("Error creating seed table")
+		}
+
+		seedKeysFromDatabase, kErr := utils.GetSeedsFromDatabase(db)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting seeds from database")
+		}
+
+		seedObjects, _ = utils.SplitMigrationsByFilter(
+			utils.SortMigrationObjects(seedObjects),
+			utils.GetMigrationFilterArg(cmd),
+		)
+
+		seedObjectsToRun := []types.MigrationObject{}
+
+		for _, seedObject := range seedObjects {
+			if !glob.Glob(limitPattern, seedObject.Key) {
+				continue
+			}
+
+			hasRun := utils.FindIndexInString(seedKeysFromDatabase, func(value string, index int) bool {
+				return value == seedObject.Key
+			}) != -1
+
+			if !hasRun || seedObject.Directives.Rerunnable {
+				seedObjectsToRun = append(seedObjectsToRun, seedObject)
+			}
+		}
+
+		if len(seedObjectsToRun) == 0 {
+			utils.PrintWarning("No seeds to run after filtering")
+			return nil
+		}
+
+		utils.PrintStmt("The following seeds will be run:")
+
+		var seedKeys []string = []string{}
+		for _, seedObject := range seedObjectsToRun {
+			seedKeys = append(seedKeys, seedObject.Key)
+		}
+
+		utils.PrintOrderedList(seedKeys)
+		res := utils.AskForConfirmation("Continue?", "y")
+
+		if !res {
+			return errors.WarningError.New("Aborting seeding")
+		}
+
+		vars, kErr := utils.GetTemplateVars(cmd)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting template variables")
+		}
+
+		ctx, cancel := utils.GetRunContext(cmd)
+		defer cancel()
+
+		for _, seedObject := range seedObjectsToRun {
+			fileContent, kErr := utils.GetRenderedMigrationContent(seedDir, seedObject.File, vars)
+
+			if kErr != nil {
+				return kErr.Explainf("Error getting seed content: %s", seedObject.File)
+			}
+
+			kErr = utils.RunMigration(ctx, db, seedObject, fileContent)
+
+			if kErr != nil {
+				return kErr.Explainf("Error running seed: %s", seedObject.File)
+			}
+
+			hasRun := utils.FindIndexInString(seedKeysFromDatabase, func(value string, index int) bool {
+				return value == seedObject.Key
+			}) != -1
+
+			kErr = utils.RecordSeed(db, seedObject.Key, hasRun)
+
+			if kErr != nil {
+				return kErr.Explainf("Error recording seed: %s", seedObject.Key)
+			}
+		}
+
+		utils.PrintSuccess("Seeds run successfully")
+		return nil
+	}),
+}
+
+var seedCreateCmd = &cobra.Command{
+	Use:   "create [seedName]",
+	Short: "Create a seed",
+	Run: utils.CreateCmdHandler(func(cmd *cobra.Command, args []string) *khata.Khata {
+		utils.LoadEnvFile(utils.GetStringArg(cmd, "dotenvfile", "", ""))
+
+		var seedName string
+
+		if len(args) > 0 {
+			seedName = args[0]
+		}
+
+		if seedName == "" {
+			return errors.FatalError.New("seed name is required")
+		}
+
+		// validate the seed name
+		if !utils.ValidateMigrationName(seedName) {
+			return errors.FatalError.New("invalid seed name")
+		}
+
+		seedDir, kErr := utils.GetSeedPath("")
+
+		if kErr != nil {
+			return kErr.Explain("Error getting seed path")
+		}
+
+		// Projects initialized before seeds existed have no seeds directory
+		if _, err := os.Stat(seedDir); os.IsNotExist(err) {
+			err := os.Mkdir(seedDir, 0755)
+
+			if err != nil {
+				return khata.Wrap(err).Explain("Error creating seeds directory")
+			}
+		}
+
+		config, kErr := utils.LoadConfig()
+
+		if kErr != nil {
+			return kErr.Explain("Error loading config")
+		}
+
+		// Get the version prefix of the seed from the existing ones
+		existingSeedObjects := []types.MigrationObject{}
+
+		kErr = utils.GetSeedObjectsFromDir(seedDir, &existingSeedObjects)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting seed objects")
+		}
+
+		existingSeedKeys := []string{}
+		for _, seedObject := range existingSeedObjects {
+			existingSeedKeys = append(existingSeedKeys, seedObject.Key)
+		}
+
+		versionPrefix, kErr := utils.GetNextMigrationPrefix(
+			utils.GetVersioningScheme(config, utils.GetStringArg(cmd, "versioning", "", "")),
+			existingSeedKeys,
+			time.Now(),
+			utils.GetStringArg(cmd, "version", "", ""),
+		)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting seed version")
+		}
+
+		seedPath := path.Join(seedDir, versionPrefix+seedName+".sql")
+
+		_, err := os.Stat(seedPath)
+
+		if err == nil {
+			utils.PrintWarning("Seed file already exists")
+		} else if os.IsNotExist(err) {
+			_, err := os.Create(seedPath)
+			if err != nil {
+				return errors.FatalError.New("error creating seed file")
+			}
+		}
+
+		utils.PrintSuccess("Seed file created successfully")
+		return nil
+	}),
+}
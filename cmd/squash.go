@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/types"
+	"github.com/cmseguin/monarch/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(squashCmd)
+	squashCmd.Flags().String("until", "", "Version of the last migration to squash")
+	squashCmd.Flags().String("scratch-driver", "", "Driver of the scratch database the migrations are replayed against (default sqlite)")
+	squashCmd.Flags().String("scratch-connection", "", "Connection string of the scratch database (default in-memory sqlite)")
+	squashCmd.Flags().StringArray("var", []string{}, "Template variable for the migrations as key=value")
+}
+
+var squashCmd = &cobra.Command{
+	Use:   "squash --until <version>",
+	Short: "Replace the migrations up to a version with a single baseline migration",
+	Run: utils.CreateCmdHandler(func(cmd *cobra.Command, args []string) *khata.Khata {
+		utils.LoadEnvFile(utils.GetStringArg(cmd, "dotenvfile", "", ""))
+
+		until := utils.GetStringArg(cmd, "until", "", "")
+
+		if until == "" {
+			return errors.FatalError.New("--until is required")
+		}
+
+		untilVersion, ok := utils.ParseVersionArg(until)
+
+		if !ok {
+			return errors.FatalError.New("invalid version: " + until)
+		}
+
+		migrationDir, kErr := utils.GetMigrationPath("")
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration path")
+		}
+
+		migrationObjects := []types.MigrationObject{}
+
+		kErr = utils.GetUpMigratrionObjectsFromDir(migrationDir, &migrationObjects)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration objects")
+		}
+
+		migrationObjectsToSquash := []types.MigrationObject{}
+		squashedKeys := []string{}
+
+		for _, migrationObject := range utils.SortMigrationObjects(migrationObjects) {
+			version, ok := utils.ParseMigrationVersion(migrationObject.Key)
+
+			if !ok || utils.CompareMigrationVersions(version, untilVersion) > 0 {
+				continue
+			}
+
+			// A baseline cannot tell which environment it runs in
+			if len(migrationObject.Directives.Envs) > 0 || len(migrationObject.Directives.Tags) > 0 {
+				return errors.FatalError.New("cannot squash migration " + migrationObject.Key + " restricted to environments or tags")
+			}
+
+			migrationObjectsToSquash = append(migrationObjectsToSquash, migrationObject)
+
+			// Squashing a previous baseline carries over what it replaced
+			squashedKeys = append(squashedKeys, migrationObject.Directives.Squashes...)
+			squashedKeys = append(squashedKeys, migrationObject.Key)
+		}
+
+		if len(migrationObjectsToSquash) < 2 {
+			return errors.WarningError.New("Nothing to squash, at least two migrations are required")
+		}
+
+		// Build the schema produced by the migrations on a scratch database
+		scratchDb, kErr := utils.OpenScratchDatabaseArg(cmd)
+
+		if kErr != nil {
+			return kErr.Explain("Error connecting to the scratch database")
+		}
+
+		defer scratchDb.Close()
+
+		vars, kErr := utils.GetTemplateVars(cmd)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting template variables")
+		}
+
+		ctx, cancel := utils.GetRunContext(cmd)
+		kErr = utils.ApplyMigrationsToDatabase(ctx, scratchDb, migrationDir, migrationObjectsToSquash, vars)
+
+		// Let Ctrl-C abort the confirmation prompt again
+		cancel()
+
+		if kErr != nil {
+			return kErr.Explain("Error replaying migrations on the scratch database")
+		}
+
+		schema, kErr := utils.IntrospectSchema(scratchDb)
+
+		if kErr != nil {
+			return kErr.Explain("Error introspecting the scratch database")
+		}
+
+		lastMigrationObject := migrationObjectsToSquash[len(migrationObjectsToSquash)-1]
+		baselineKey := utils.GetMigrationVersionPrefix(lastMigrationObject.Key) + "baseline"
+		baselineContent := fmt.Sprintf(
+			"-- monarch:irreversible\n-- monarch:squashes=%s\n-- Baseline of %d migrations generated by monarch squash\n\n%s",
+			strings.Join(squashedKeys, ","),
+			len(migrationObjectsToSquash),
+			utils.RenderSchemaSQL(schema),
+		)
+
+		var migrationKeys []string = []string{}
+		for _, migrationObject := range migrationObjectsToSquash {
+			migrationKeys = append(migrationKeys, migrationObject.Key)
+		}
+
+		utils.PrintStmt(fmt.Sprintf("The following migrations will be squashed into %s:", baselineKey))
+		utils.PrintOrderedList(migrationKeys)
+
+		res := utils.AskForConfirmation("Continue?", "n")
+
+		if !res {
+			return errors.WarningError.New("Aborting squash")
+		}
+
+		// Archive the originals, the loader ignores directories
+		archiveDir := path.Join(migrationDir, ".archive")
+
+		if err := os.MkdirAll(archiveDir, 0755); err != nil {
+			return khata.Wrap(err).Explain("Error creating archive directory")
+		}
+
+		for _, migrationObject := range migrationObjectsToSquash {
+			for _, suffix := range []string{".up.sql", ".down.sql"} {
+				file := migrationObject.Key + suffix
+
+				if _, err := os.Stat(path.Join(migrationDir, file)); os.IsNotExist(err) {
+					continue
+				}
+
+				if err := os.Rename(path.Join(migrationDir, file), path.Join(archiveDir, file)); err != nil {
+					return khata.Wrap(err).Explainf("Error archiving migration %s", migrationObject.Key)
+				}
+			}
+		}
+
+		err := os.WriteFile(path.Join(migrationDir, baselineKey+".up.sql"), []byte(baselineContent), 0644)
+
+		if err != nil {
+			return khata.Wrap(err).Explain("Error writing baseline migration")
+		}
+
+		utils.PrintSuccess(fmt.Sprintf("Squashed %d migrations into %s", len(migrationObjectsToSquash), baselineKey))
+		return nil
+	}),
+}
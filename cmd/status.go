@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/types"
+	"github.com/cmseguin/monarch/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().String("env", "", "Environment to report the migrations for")
+	statusCmd.Flags().String("tags", "", "Only consider the migrations with one of these comma separated tags")
+	statusCmd.Flags().String("exclude-tags", "", "Skip the migrations with one of these comma separated tags")
+	statusCmd.Flags().StringArray("var", []string{}, "Template variable for the migrations as key=value")
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the applied, pending and skipped migrations",
+	Run: utils.CreateCmdHandler(func(cmd *cobra.Command, args []string) *khata.Khata {
+		utils.LoadEnvFile(utils.GetStringArg(cmd, "dotenvfile", "", ""))
+
+		migrationDir, kErr := utils.GetMigrationPath("")
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration path")
+		}
+
+		migrationObjects := []types.MigrationObject{}
+
+		kErr = utils.GetUpMigratrionObjectsFromDir(migrationDir, &migrationObjects)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration objects")
+		}
+
+		vars, kErr := utils.GetTemplateVars(cmd)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting template variables")
+		}
+
+		repeatableMigrationObjects := []types.MigrationObject{}
+
+		kErr = utils.GetRepeatableMigrationObjectsFromDir(migrationDir, vars, &repeatableMigrationObjects)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting repeatable migration objects")
+		}
+
+		db, kErr := utils.InitDb(cmd)
+
+		if kErr != nil {
+			return kErr.Explain("Error connecting to the database")
+		}
+
+		kErr = utils.UpgradeMigrationTable(db)
+
+		if kErr != nil {
+			return kErr.Explain("Error upgrading the migrations table")
+		}
+
+		migrationsFromDb, kErr := utils.GetAllMigrationsFromDatabase(db)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting all migrations from database")
+		}
+
+		migrationsFromDbMap := map[string]types.Migration{}
+		appliedKeysFromDb := []string{}
+		for _, m := range migrationsFromDb {
+			migrationsFromDbMap[m.Key] = m
+
+			if m.IsApplied {
+				appliedKeysFromDb = append(appliedKeysFromDb, m.Key)
+			}
+		}
+
+		filter := utils.GetMigrationFilterArg(cmd)
+
+		appliedMigrationKeys := []string{}
+		pendingMigrationKeys := []string{}
+		skippedMigrationKeys := []string{}
+
+		for _, migrationObject := range utils.SortMigrationObjects(migrationObjects) {
+			migration := migrationsFromDbMap[migrationObject.Key]
+
+			if migration.IsApplied {
+				details := []string{}
+
+				if migration.Batch > 0 {
+					details = append(details, fmt.Sprintf("batch %d", migration.Batch))
+				}
+
+				if migration.Filter != "" {
+					details = append(details, migration.Filter)
+				}
+
+				if len(details) > 0 {
+					appliedMigrationKeys = append(appliedMigrationKeys, migrationObject.Key+" ("+strings.Join(details, ", ")+")")
+				} else {
+					appliedMigrationKeys = append(appliedMigrationKeys, migrationObject.Key)
+				}
+
+				continue
+			}
+
+			isSatisfied, kErr := utils.IsBaselineSatisfied(migrationObject, appliedKeysFromDb)
+
+			if kErr != nil {
+				// A partial squash range needs fixing before up runs, it is
+				// listed as pending rather than failing the whole status
+				pendingMigrationKeys = append(pendingMigrationKeys, migrationObject.Key+" (partially applied squash range)")
+			} else if isSatisfied {
+				appliedMigrationKeys = append(appliedMigrationKeys, migrationObject.Key+" (via squashed migrations)")
+			} else if utils.IsMigrationSelected(migrationObject.Directives, filter) {
+				pendingMigrationKeys = append(pendingMigrationKeys, migrationObject.Key)
+			} else {
+				skippedMigrationKeys = append(skippedMigrationKeys, migrationObject.Key)
+			}
+		}
+
+		utils.PrintInfo("Applied migrations:")
+		utils.PrintOrderedList(appliedMigrationKeys)
+
+		utils.PrintInfo("Pending migrations:")
+		utils.PrintOrderedList(pendingMigrationKeys)
+
+		utils.PrintInfo("Skipped by filter:")
+		utils.PrintOrderedList(skippedMigrationKeys)
+
+		staleRepeatableKeys := []string{}
+		for _, migrationObject := range utils.FilterStaleRepeatableMigrations(
+			utils.SortMigrationObjects(repeatableMigrationObjects),
+			migrationsFromDbMap,
+		) {
+			staleRepeatableKeys = append(staleRepeatableKeys, migrationObject.Key)
+		}
+
+		utils.PrintInfo("Stale repeatable migrations:")
+		utils.PrintOrderedList(staleRepeatableKeys)
+
+		dirtyMigrationKeys := []string{}
+		for _, migration := range migrationsFromDb {
+			if migration.State == types.MigrationStateRunning || migration.State == types.MigrationStateFailed {
+				dirtyMigrationKeys = append(dirtyMigrationKeys, migration.Key+" ("+migration.State+")")
+			}
+		}
+
+		if len(dirtyMigrationKeys) > 0 {
+			utils.PrintWarning("Dirty migrations, resolve them with `monarch force`:")
+			utils.PrintOrderedList(dirtyMigrationKeys)
+		}
+
+		return nil
+	}),
+}
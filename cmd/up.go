@@ -10,6 +10,17 @@ import (
 
 func init() {
 	rootCmd.AddCommand(upCmd)
+	upCmd.Flags().String("env", "", "Environment to run the migrations for")
+	upCmd.Flags().String("tags", "", "Only run the migrations with one of these comma separated tags")
+	upCmd.Flags().String("exclude-tags", "", "Skip the migrations with one of these comma separated tags")
+	upCmd.Flags().StringArray("var", []string{}, "Template variable for the migrations as key=value")
+	upCmd.Flags().Bool("allow-out-of-order", false, "Run pending migrations older than the latest applied one")
+	upCmd.Flags().Bool("dump-schema", false, "Dump the schema to schema.sql after the migrations ran")
+	upCmd.Flags().Bool("dry-run", false, "Print the rendered migrations without running them")
+	upCmd.Flags().Duration("migration-timeout", 0, "Timeout of each migration without a timeout directive")
+	upCmd.Flags().Int("retries", 0, "Times a migration failing with a transient error is retried when it ran in a transaction")
+	upCmd.Flags().String("lock-timeout", "", "PostgreSQL lock_timeout to set for the migration session (e.g. 5s)")
+	upCmd.Flags().String("statement-timeout", "", "PostgreSQL statement_timeout to set for the migration session (e.g. 5min)")
 }
 
 var upCmd = &cobra.Command{
@@ -41,18 +52,42 @@ var upCmd = &cobra.Command{
 			return kErr.Explain("Error getting migration objects")
 		}
 
-		if len(migrationObjects) == 0 {
+		vars, kErr := utils.GetTemplateVars(cmd)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting template variables")
+		}
+
+		repeatableMigrationObjects := []types.MigrationObject{}
+
+		kErr = utils.GetRepeatableMigrationObjectsFromDir(migrationDir, vars, &repeatableMigrationObjects)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting repeatable migration objects")
+		}
+
+		if len(migrationObjects) == 0 && len(repeatableMigrationObjects) == 0 {
 			utils.PrintWarning("No migrations found")
 			return nil
 		}
 
 		// Filter out the down migrations
-		db, kErr := utils.InitDb(cmd)
+		db, kErr := utils.InitDbWithTimeouts(
+			cmd,
+			utils.GetStringArg(cmd, "lock-timeout", "MONARCH_LOCK_TIMEOUT", ""),
+			utils.GetStringArg(cmd, "statement-timeout", "MONARCH_STATEMENT_TIMEOUT", ""),
+		)
 
 		if kErr != nil {
 			return kErr.Explain("Error connecting to the database")
 		}
 
+		kErr = utils.UpgradeMigrationTable(db)
+
+		if kErr != nil {
+			return kErr.Explain("Error upgrading the migrations table")
+		}
+
 		// Get the list of migrations that have already been run
 		invalidMigrationKeysFromDatabase, kErr := utils.GetMigrationsFromDatabase(db, true)
 
@@ -69,18 +104,138 @@ var upCmd = &cobra.Command{
 			invalidMigrationKeysFromDatabase,
 		)
 
-		if len(migrationObjectsToRun) == 0 {
+		// Skip the migrations that are not selected by the filter
+		filter := utils.GetMigrationFilterArg(cmd)
+		migrationObjectsToRun, skippedMigrationObjects := utils.SplitMigrationsByFilter(migrationObjectsToRun, filter)
+
+		if len(skippedMigrationObjects) > 0 {
+			var skippedMigrationKeys []string = []string{}
+			for _, migrationObject := range skippedMigrationObjects {
+				skippedMigrationKeys = append(skippedMigrationKeys, migrationObject.Key)
+			}
+
+			utils.PrintWarning("The following migrations are skipped by the filter:")
+			utils.PrintUnorderedList(skippedMigrationKeys)
+		}
+
+		// Baselines standing for migrations that were already applied are only recorded
+		satisfiedBaselineObjects := []types.MigrationObject{}
+		remainingMigrationObjects := []types.MigrationObject{}
+
+		for _, migrationObject := range migrationObjectsToRun {
+			isSatisfied, kErr := utils.IsBaselineSatisfied(migrationObject, invalidMigrationKeysFromDatabase)
+
+			if kErr != nil {
+				return kErr.Explain("Error checking squashed migrations")
+			}
+
+			if isSatisfied {
+				satisfiedBaselineObjects = append(satisfiedBaselineObjects, migrationObject)
+			} else {
+				remainingMigrationObjects = append(remainingMigrationObjects, migrationObject)
+			}
+		}
+
+		migrationObjectsToRun = remainingMigrationObjects
+
+		migrationsFromDbMap := map[string]types.Migration{}
+		migrationsFromDb, kErr := utils.GetAllMigrationsFromDatabase(db)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting all migrations from database")
+		}
+
+		for _, m := range migrationsFromDb {
+			migrationsFromDbMap[m.Key] = m
+		}
+
+		// Refuse to migrate a database left halfway through a migration
+		kErr = utils.CheckDirtyMigrations(migrationsFromDb)
+
+		if kErr != nil {
+			return kErr
+		}
+
+		// Repeatable migrations run after the versioned ones when they changed
+		repeatableMigrationObjects, _ = utils.SplitMigrationsByFilter(
+			utils.SortMigrationObjects(repeatableMigrationObjects),
+			filter,
+		)
+		repeatableMigrationObjectsToRun := utils.FilterStaleRepeatableMigrations(repeatableMigrationObjects, migrationsFromDbMap)
+
+		if len(migrationObjectsToRun) == 0 && len(repeatableMigrationObjectsToRun) == 0 && len(satisfiedBaselineObjects) == 0 {
 			utils.PrintWarning("no applied migration migrations to run after filtering")
 			return nil
 		}
 
+		// Refuse to run migrations older than the latest applied one unless allowed
+		outOfOrderMigrationObjects := utils.FindOutOfOrderMigrations(migrationObjectsToRun, invalidMigrationKeysFromDatabase)
+
+		if len(outOfOrderMigrationObjects) > 0 && !utils.GetBoolArg(cmd, "allow-out-of-order", "MONARCH_ALLOW_OUT_OF_ORDER", false) {
+			var outOfOrderMigrationKeys []string = []string{}
+			for _, migrationObject := range outOfOrderMigrationObjects {
+				outOfOrderMigrationKeys = append(outOfOrderMigrationKeys, migrationObject.Key)
+			}
+
+			return errors.FatalError.New(
+				"pending migrations are older than the latest applied migration, run `monarch renumber` or pass --allow-out-of-order",
+				utils.SPrintUnorderedList(outOfOrderMigrationKeys),
+			)
+		}
+
+		// Make sure the required migrations are applied or run first
+		previousMigrationKeys := []string{}
+
+		for _, migrationObject := range migrationObjectsToRun {
+			kErr = utils.CheckMigrationRequirements(
+				migrationObject,
+				utils.AddSquashedMigrationKeys(sortedMigrations, invalidMigrationKeysFromDatabase),
+				utils.AddSquashedMigrationKeys(sortedMigrations, previousMigrationKeys),
+			)
+
+			if kErr != nil {
+				return kErr.Explain("Error checking migration requirements")
+			}
+
+			previousMigrationKeys = append(previousMigrationKeys, migrationObject.Key)
+		}
+
+		// Render every migration up front so an undefined variable fails early
+		renderedMigrations := map[string]string{}
+
+		for _, migrationObject := range append(append([]types.MigrationObject{}, migrationObjectsToRun...), repeatableMigrationObjectsToRun...) {
+			fileContent, kErr := utils.GetRenderedMigrationContent(migrationDir, migrationObject.File, vars)
+
+			if kErr != nil {
+				return kErr.Explainf("Error getting migration content: %s", migrationObject.File)
+			}
+
+			renderedMigrations[migrationObject.Key] = fileContent
+		}
+
+		if utils.GetBoolArg(cmd, "dry-run", "", false) {
+			for _, migrationObject := range append(append([]types.MigrationObject{}, migrationObjectsToRun...), repeatableMigrationObjectsToRun...) {
+				utils.PrintInfo("-- " + migrationObject.File)
+				utils.PrintStmt(renderedMigrations[migrationObject.Key])
+			}
+
+			utils.PrintWarning("Dry run, no migration was run")
+			return nil
+		}
+
 		// Print the migrations that are going to be run
 		utils.PrintStmt("The following migration will be run:")
 
 		var migrationKeys []string = []string{}
+		for _, migrationObject := range satisfiedBaselineObjects {
+			migrationKeys = append(migrationKeys, migrationObject.Key+" (baseline, recorded without running)")
+		}
 		for _, migrationObject := range migrationObjectsToRun {
 			migrationKeys = append(migrationKeys, migrationObject.Key)
 		}
+		for _, migrationObject := range repeatableMigrationObjectsToRun {
+			migrationKeys = append(migrationKeys, migrationObject.Key+" (repeatable)")
+		}
 
 		utils.PrintOrderedList(migrationKeys)
 		res := utils.AskForConfirmation("Continue?", "y")
@@ -89,46 +244,93 @@ var upCmd = &cobra.Command{
 			return errors.WarningError.New("Aborting migration")
 		}
 
-		migrationsFromDbMap := map[string]types.Migration{}
-		migrationsFromDb, kErr := utils.GetAllMigrationsFromDatabase(db)
+		ctx, cancel := utils.GetRunContext(cmd)
+		defer cancel()
+
+		// Keep other monarch processes from migrating the database meanwhile
+		release, kErr := utils.LockMigrations(ctx, db, invalidMigrationKeysFromDatabase)
 
 		if kErr != nil {
-			return kErr.Explain("Error getting all migrations from database")
+			return kErr
 		}
 
-		for _, m := range migrationsFromDb {
-			migrationsFromDbMap[m.Key] = m
+		defer release()
+
+		migrationObjectsToRun = utils.SetDefaultMigrationDirectives(cmd, migrationObjectsToRun)
+		repeatableMigrationObjectsToRun = utils.SetDefaultMigrationDirectives(cmd, repeatableMigrationObjectsToRun)
+
+		// Every migration applied by this run belongs to the same batch
+		batch, kErr := utils.GetNextMigrationBatch(db)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting the migration batch")
+		}
+
+		// Record the baselines of migrations that were already applied
+		for _, migrationObject := range satisfiedBaselineObjects {
+			if migrationsFromDbMap[migrationObject.Key].Key != migrationObject.Key {
+				kErr = utils.CreateMigrationEntry(db, migrationObject.Key)
+			}
+
+			if kErr != nil {
+				return kErr.Explainf("Error creating migration entry: %s", migrationObject.Key)
+			}
+
+			kErr = utils.ApplyMigration(db, migrationObject.Key, utils.FormatMigrationFilter(filter), batch)
+
+			if kErr != nil {
+				return kErr.Explainf("Error updating the status of migration: %s", migrationObject.Key)
+			}
 		}
 
 		// Run the migrations
 		for _, migrationObject := range migrationObjectsToRun {
-			fileContent, kErr := utils.GetMigrationContent(migrationDir, migrationObject.File)
+			kErr = utils.RunTrackedMigration(ctx, db, migrationObject, renderedMigrations[migrationObject.Key], migrationsFromDbMap[migrationObject.Key])
 
 			if kErr != nil {
-				return kErr.Explainf("Error getting migration content: %s", migrationObject.File)
+				return kErr
 			}
 
-			// Run the migration
-			kErr = utils.ExecuteMigration(db, fileContent)
+			kErr = utils.ApplyMigration(db, migrationObject.Key, utils.FormatMigrationFilter(filter), batch)
 
 			if kErr != nil {
-				return kErr.Explainf("Error running migration: %s", migrationObject.File)
+				return kErr.Explainf("Error updating the status of migration: %s", migrationObject.Key)
 			}
+		}
 
-			// Check if the migration is already in the database otherwise add it
-			if migrationsFromDbMap[migrationObject.Key].Key != migrationObject.Key {
-				kErr = utils.CreateMigrationEntry(db, migrationObject.Key)
-			}
+		// Re-apply the repeatable migrations that changed
+		for _, migrationObject := range repeatableMigrationObjectsToRun {
+			kErr = utils.RunTrackedMigration(ctx, db, migrationObject, renderedMigrations[migrationObject.Key], migrationsFromDbMap[migrationObject.Key])
 
 			if kErr != nil {
-				return kErr.Explainf("Error creating migration entry: %s", migrationObject.Key)
+				return kErr
 			}
 
-			kErr = utils.ApplyMigration(db, migrationObject.Key)
+			kErr = utils.ApplyMigration(db, migrationObject.Key, utils.FormatMigrationFilter(filter), batch)
 
 			if kErr != nil {
 				return kErr.Explainf("Error updating the status of migration: %s", migrationObject.Key)
 			}
+
+			kErr = utils.SetMigrationChecksum(db, migrationObject.Key, migrationObject.Checksum)
+
+			if kErr != nil {
+				return kErr.Explainf("Error updating the checksum of migration: %s", migrationObject.Key)
+			}
+		}
+
+		if utils.GetBoolArg(cmd, "dump-schema", "MONARCH_DUMP_SCHEMA", false) {
+			dumpPath, kErr := utils.GetSchemaDumpPath(cmd)
+
+			if kErr != nil {
+				return kErr.Explain("Error getting schema dump path")
+			}
+
+			kErr = utils.DumpSchema(db, dumpPath)
+
+			if kErr != nil {
+				return kErr.Explain("Error dumping schema")
+			}
 		}
 
 		utils.PrintSuccess("Migrations run successfully")
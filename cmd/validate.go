@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/types"
+	"github.com/cmseguin/monarch/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+	validateCmd.Flags().String("against", "", "Environment whose database the pending migrations are checked against")
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the migrations and their order against a database",
+	Run: utils.CreateCmdHandler(func(cmd *cobra.Command, args []string) *khata.Khata {
+		utils.LoadEnvFile(utils.GetStringArg(cmd, "dotenvfile", "", ""))
+
+		migrationDir, kErr := utils.GetMigrationPath("")
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration path")
+		}
+
+		// Loading the migrations also validates their directives
+		migrationObjects := []types.MigrationObject{}
+
+		kErr = utils.GetUpMigratrionObjectsFromDir(migrationDir, &migrationObjects)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration objects")
+		}
+
+		downMigrationObjects := []types.MigrationObject{}
+
+		kErr = utils.GetDownMigratrionObjectsFromDir(migrationDir, &downMigrationObjects)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration objects")
+		}
+
+		problems := []string{}
+		warnings := []string{}
+
+		sortedMigrations := utils.SortMigrationObjects(migrationObjects)
+
+		for i, migrationObject := range sortedMigrations {
+			if i > 0 {
+				previousVersion, okPrevious := utils.ParseMigrationVersion(sortedMigrations[i-1].Key)
+				version, ok := utils.ParseMigrationVersion(migrationObject.Key)
+
+				if ok && okPrevious && utils.CompareMigrationVersions(previousVersion, version) == 0 {
+					problems = append(problems, fmt.Sprintf("%s and %s share the same version", sortedMigrations[i-1].Key, migrationObject.Key))
+				}
+			}
+
+			hasDown := false
+			for _, downMigrationObject := range downMigrationObjects {
+				if downMigrationObject.Key == migrationObject.Key {
+					hasDown = true
+				}
+			}
+
+			if !hasDown && !migrationObject.Directives.Irreversible {
+				warnings = append(warnings, fmt.Sprintf("%s has no down migration", migrationObject.Key))
+			}
+		}
+
+		if utils.GetStringArg(cmd, "against", "", "") != "" {
+			db, kErr := utils.InitDb(cmd)
+
+			if kErr != nil {
+				return kErr.Explain("Error connecting to the database")
+			}
+
+			appliedMigrationKeys, kErr := utils.GetMigrationsFromDatabase(db, true)
+
+			if kErr != nil {
+				return kErr.Explain("Error getting migrations from database")
+			}
+
+			pendingMigrationObjects := utils.FilterMigrationToRun("*", sortedMigrations, appliedMigrationKeys)
+
+			for _, migrationObject := range utils.FindOutOfOrderMigrations(pendingMigrationObjects, appliedMigrationKeys) {
+				problems = append(problems, fmt.Sprintf("%s is pending but older than the latest applied migration", migrationObject.Key))
+			}
+
+			for _, migrationObject := range sortedMigrations {
+				fileContent, kErr := utils.GetMigrationContent(migrationDir, migrationObject.File)
+
+				if kErr != nil {
+					return kErr.Explainf("Error getting migration content: %s", migrationObject.File)
+				}
+
+				for _, hint := range utils.CheckMigrationLocks(utils.GetDialect(db), migrationObject.Key, fileContent) {
+					warnings = append(warnings, fmt.Sprintf("%s: %s (%s lock)", hint.Key, hint.Message, hint.Lock))
+				}
+			}
+		}
+
+		if len(warnings) > 0 {
+			utils.PrintWarning("Warnings:")
+			utils.PrintUnorderedList(warnings)
+		}
+
+		if len(problems) > 0 {
+			utils.PrintErrorMessage("Problems:")
+			utils.PrintUnorderedList(problems)
+
+			return errors.FatalError.New(fmt.Sprintf("%d problems found", len(problems)))
+		}
+
+		utils.PrintSuccess("Migrations are valid")
+		return nil
+	}),
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/types"
+	"github.com/cmseguin/monarch/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(verifyRoundtripCmd)
+	verifyRoundtripCmd.Flags().String("scratch-driver", "", "Driver of the scratch database the migrations are run against (default sqlite)")
+	verifyRoundtripCmd.Flags().String("scratch-connection", "", "Connection string of the scratch database (default in-memory sqlite)")
+	verifyRoundtripCmd.Flags().StringArray("var", []string{}, "Template variable for the migrations as key=value")
+}
+
+var verifyRoundtripCmd = &cobra.Command{
+	Use:   "verify-roundtrip",
+	Short: "Check on a scratch database that every down migration reverses its up migration",
+	Run: utils.CreateCmdHandler(func(cmd *cobra.Command, args []string) *khata.Khata {
+		utils.LoadEnvFile(utils.GetStringArg(cmd, "dotenvfile", "", ""))
+
+		migrationDir, kErr := utils.GetMigrationPath("")
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration path")
+		}
+
+		migrationObjects := []types.MigrationObject{}
+
+		kErr = utils.GetUpMigratrionObjectsFromDir(migrationDir, &migrationObjects)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration objects")
+		}
+
+		downMigrationObjects := []types.MigrationObject{}
+
+		kErr = utils.GetDownMigratrionObjectsFromDir(migrationDir, &downMigrationObjects)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting migration objects")
+		}
+
+		downMigrationObjectsMap := map[string]types.MigrationObject{}
+		for _, downMigrationObject := range downMigrationObjects {
+			downMigrationObjectsMap[downMigrationObject.Key] = downMigrationObject
+		}
+
+		vars, kErr := utils.GetTemplateVars(cmd)
+
+		if kErr != nil {
+			return kErr.Explain("Error getting template variables")
+		}
+
+		scratchDb, kErr := utils.OpenScratchDatabaseArg(cmd)
+
+		if kErr != nil {
+			return kErr.Explain("Error connecting to the scratch database")
+		}
+
+		defer scratchDb.Close()
+
+		kErr = utils.ResetScratchDatabase(scratchDb)
+
+		if kErr != nil {
+			return kErr.Explain("Error resetting the scratch database")
+		}
+
+		ctx, cancel := utils.GetRunContext(cmd)
+		defer cancel()
+
+		// Rebuilds the scratch database up to and including a migration after a
+		// failure left it in an unknown state
+		rebuild := func(appliedMigrationObjects []types.MigrationObject) *khata.Khata {
+			kErr := utils.ResetScratchDatabase(scratchDb)
+
+			if kErr != nil {
+				return kErr
+			}
+
+			return utils.ApplyMigrationsToDatabase(ctx, scratchDb, migrationDir, appliedMigrationObjects, vars)
+		}
+
+		sortedMigrations := utils.SortMigrationObjects(migrationObjects)
+		problems := []string{}
+		verified := 0
+
+		for i, migrationObject := range sortedMigrations {
+			before, kErr := utils.IntrospectSchema(scratchDb)
+
+			if kErr != nil {
+				return kErr.Explain("Error introspecting the scratch database")
+			}
+
+			kErr = utils.ApplyMigrationsToDatabase(ctx, scratchDb, migrationDir, []types.MigrationObject{migrationObject}, vars)
+
+			if kErr != nil {
+				// The following migrations depend on this one, stop here
+				problems = append(problems, fmt.Sprintf("%s: up migration fails: %s", migrationObject.Key, kErr.Error()))
+				break
+			}
+
+			if migrationObject.Directives.Irreversible {
+				utils.PrintInfo(migrationObject.Key + ": irreversible, skipped")
+				continue
+			}
+
+			downMigrationObject, ok := downMigrationObjectsMap[migrationObject.Key]
+
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: down migration is missing", migrationObject.Key))
+				continue
+			}
+
+			kErr = utils.ApplyMigrationsToDatabase(ctx, scratchDb, migrationDir, []types.MigrationObject{downMigrationObject}, vars)
+
+			if kErr != nil {
+				problems = append(problems, fmt.Sprintf("%s: down migration fails: %s", migrationObject.Key, kErr.Error()))
+
+				if kErr := rebuild(sortedMigrations[:i+1]); kErr != nil {
+					return kErr.Explain("Error rebuilding the scratch database")
+				}
+
+				continue
+			}
+
+			after, kErr := utils.IntrospectSchema(scratchDb)
+
+			if kErr != nil {
+				return kErr.Explain("Error introspecting the scratch database")
+			}
+
+			if diff := utils.DiffSchemas(before, after); !utils.IsSchemaDiffEmpty(diff) {
+				problems = append(problems, fmt.Sprintf("%s: down migration does not restore the previous schema", migrationObject.Key))
+				utils.PrintWarning(migrationObject.Key + ": schema after down differs from the schema before up")
+				utils.PrintSchemaDiff(diff)
+
+				if kErr := rebuild(sortedMigrations[:i+1]); kErr != nil {
+					return kErr.Explain("Error rebuilding the scratch database")
+				}
+
+				continue
+			}
+
+			// Reapply the migration so the next one runs on top of it
+			kErr = utils.ApplyMigrationsToDatabase(ctx, scratchDb, migrationDir, []types.MigrationObject{migrationObject}, vars)
+
+			if kErr != nil {
+				problems = append(problems, fmt.Sprintf("%s: up migration fails after its down migration: %s", migrationObject.Key, kErr.Error()))
+
+				if kErr := rebuild(sortedMigrations[:i+1]); kErr != nil {
+					return kErr.Explain("Error rebuilding the scratch database")
+				}
+
+				continue
+			}
+
+			verified++
+		}
+
+		if len(problems) > 0 {
+			utils.PrintErrorMessage("Problems:")
+			utils.PrintUnorderedList(problems)
+
+			return errors.FatalError.New(fmt.Sprintf("%d migrations do not roundtrip", len(problems)))
+		}
+
+		utils.PrintSuccess(fmt.Sprintf("%d migrations roundtrip successfully", verified))
+		return nil
+	}),
+}
@@ -3,14 +3,150 @@ package types
 import "time"
 
 type MigrationObject struct {
-	Key  string
-	File string
+	Key        string
+	File       string
+	Checksum   string
+	Directives MigrationDirectives
 }
 
+type MigrationDirectives struct {
+	NoTransaction bool
+	Timeout       time.Duration
+	Retries       int
+	Irreversible  bool
+	Rerunnable    bool
+	Template      bool
+	Envs          []string
+	Tags          []string
+	Requires      []string
+	Squashes      []string
+}
+
+type MigrationFilter struct {
+	Env         string
+	Tags        []string
+	ExcludeTags []string
+}
+
+// States of a migration in the migrations table. A running or failed
+// migration leaves the database dirty until its state is forced.
+const (
+	MigrationStatePending = "pending"
+	MigrationStateRunning = "running"
+	MigrationStateApplied = "applied"
+	MigrationStateFailed  = "failed"
+)
+
 type Migration struct {
 	Id        int64
 	Key       string
 	IsApplied bool
+	Filter    string
+	Checksum  string
+	Batch     int
+	State     string
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
+
+type LockHint struct {
+	Key        string
+	Statement  string
+	Lock       string
+	Message    string
+	Suggestion string
+}
+
+type Config struct {
+	Versioning   string                       `json:"versioning"`
+	SchemaFile   string                       `json:"schema_file"`
+	Environments map[string]EnvironmentConfig `json:"environments"`
+}
+
+type EnvironmentConfig struct {
+	Driver            string            `json:"driver"`
+	Connection        string            `json:"connection"`
+	ConnectionFile    string            `json:"connection_file"`
+	ConnectionCommand string            `json:"connection_command"`
+	PasswordFile      string            `json:"password_file"`
+	TLS               *TLSConfig        `json:"tls"`
+	SessionInit       []string          `json:"session_init"`
+	Protected         bool              `json:"protected"`
+	Vars              map[string]string `json:"vars"`
+}
+
+// TLSConfig holds the TLS settings of an environment. Mode is one of disable,
+// require, verify-ca or verify-full, the latter being the default.
+type TLSConfig struct {
+	Mode       string `json:"mode"`
+	CA         string `json:"ca"`
+	Cert       string `json:"cert"`
+	Key        string `json:"key"`
+	ServerName string `json:"server_name"`
+}
+
+type CreateTemplateData struct {
+	Name    string
+	Dialect string
+	Table   string
+	Column  string
+	Type    string
+	Columns []string
+	Args    map[string]string
+}
+
+type Schema struct {
+	Dialect string
+	Tables  []SchemaTable
+	Views   []SchemaView
+}
+
+type SchemaTable struct {
+	Name        string
+	Columns     []SchemaColumn
+	Indexes     []SchemaIndex
+	Constraints []SchemaConstraint
+}
+
+type SchemaColumn struct {
+	Name     string
+	Type     string
+	Nullable bool
+	Default  string
+}
+
+type SchemaIndex struct {
+	Name    string
+	Columns []string
+	Unique  bool
+}
+
+type SchemaConstraint struct {
+	Name       string
+	Type       string
+	Definition string
+}
+
+type SchemaView struct {
+	Name       string
+	Definition string
+}
+
+type SchemaDiff struct {
+	MissingTables []string          `json:"missing_tables"`
+	ExtraTables   []string          `json:"extra_tables"`
+	Tables        []SchemaTableDiff `json:"tables"`
+	MissingViews  []string          `json:"missing_views"`
+	ExtraViews    []string          `json:"extra_views"`
+}
+
+type SchemaTableDiff struct {
+	Name               string   `json:"name"`
+	MissingColumns     []string `json:"missing_columns"`
+	ExtraColumns       []string `json:"extra_columns"`
+	ChangedColumns     []string `json:"changed_columns"`
+	MissingIndexes     []string `json:"missing_indexes"`
+	ExtraIndexes       []string `json:"extra_indexes"`
+	MissingConstraints []string `json:"missing_constraints"`
+	ExtraConstraints   []string `json:"extra_constraints"`
+}
@@ -2,7 +2,9 @@ package utils
 
 import (
 	"strconv"
+	"time"
 
+	"github.com/cmseguin/monarch/internal/types"
 	"github.com/spf13/cobra"
 )
 
@@ -10,7 +12,9 @@ func GetStringArg(cmd *cobra.Command, cobraKey, envKey string, defaultValue stri
 	var value string = ""
 
 	if cmd != nil && cobraKey != "" {
-		value, _ = cmd.Flags().GetString(cobraKey)
+		if flag := cmd.Flags().Lookup(cobraKey); flag != nil && flag.Changed {
+			value = flag.Value.String()
+		}
 	}
 
 	if value == "" && envKey != "" {
@@ -52,3 +56,37 @@ func GetIntArg(cmd *cobra.Command, cobraKey, envKey string, defaultValue int) in
 
 	return int(intValue)
 }
+
+func GetDurationArg(cmd *cobra.Command, cobraKey, envKey string, defaultValue time.Duration) time.Duration {
+	value := GetStringArg(cmd, cobraKey, envKey, "")
+
+	if value == "" {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+
+	if err != nil {
+		return defaultValue
+	}
+
+	return duration
+}
+
+func GetListArg(cmd *cobra.Command, cobraKey, envKey string) []string {
+	return splitDirectiveList(GetStringArg(cmd, cobraKey, envKey, ""))
+}
+
+func GetMigrationFilterArg(cmd *cobra.Command) types.MigrationFilter {
+	return types.MigrationFilter{
+		Env:         GetEnvironmentArg(cmd),
+		Tags:        GetListArg(cmd, "tags", "MONARCH_TAGS"),
+		ExcludeTags: GetListArg(cmd, "exclude-tags", "MONARCH_EXCLUDE_TAGS"),
+	}
+}
+
+// GetEnvironmentArg returns the environment monarch runs against, from the
+// --against or --env flag or the MONARCH_ENV variable.
+func GetEnvironmentArg(cmd *cobra.Command) string {
+	return GetStringArg(cmd, "against", "", GetStringArg(cmd, "env", "MONARCH_ENV", ""))
+}
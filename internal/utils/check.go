@@ -0,0 +1,264 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/cmseguin/monarch/internal/types"
+)
+
+type lockRule struct {
+	match      *regexp.Regexp
+	exclude    *regexp.Regexp
+	lock       string
+	message    string
+	suggestion string
+}
+
+var postgresLockRules = []lockRule{
+	{
+		match:      regexp.MustCompile(`(?is)^CREATE\s+(UNIQUE\s+)?INDEX\s`),
+		exclude:    regexp.MustCompile(`(?is)\sCONCURRENTLY\s`),
+		lock:       "SHARE",
+		message:    "CREATE INDEX blocks writes on the table until the index is built",
+		suggestion: "use CREATE INDEX CONCURRENTLY in a migration that does not run in a transaction",
+	},
+	{
+		match:      regexp.MustCompile(`(?is)^DROP\s+INDEX\s`),
+		exclude:    regexp.MustCompile(`(?is)\sCONCURRENTLY\s`),
+		lock:       "ACCESS EXCLUSIVE",
+		message:    "DROP INDEX blocks reads and writes on the table",
+		suggestion: "use DROP INDEX CONCURRENTLY in a migration that does not run in a transaction",
+	},
+	{
+		match:      regexp.MustCompile(`(?is)^ALTER\s+TABLE\s.*\sADD\s+(COLUMN\s+)?.*\sDEFAULT\s`),
+		lock:       "ACCESS EXCLUSIVE",
+		message:    "adding a column with a default rewrites the table on PostgreSQL < 11 or when the default is volatile",
+		suggestion: "add the column without a default, set the default in a separate statement and backfill in batches",
+	},
+	{
+		match:      regexp.MustCompile(`(?is)^ALTER\s+TABLE\s.*\sALTER\s+(COLUMN\s+)?\S+\s+SET\s+NOT\s+NULL`),
+		lock:       "ACCESS EXCLUSIVE",
+		message:    "SET NOT NULL scans the whole table while holding the lock",
+		suggestion: "add a CHECK (column IS NOT NULL) NOT VALID constraint, VALIDATE it, then set NOT NULL",
+	},
+	{
+		match:      regexp.MustCompile(`(?is)^ALTER\s+TABLE\s.*\sALTER\s+(COLUMN\s+)?\S+\s+(SET\s+DATA\s+)?TYPE\s`),
+		lock:       "ACCESS EXCLUSIVE",
+		message:    "changing a column type usually rewrites the table and its indexes",
+		suggestion: "add a new column, backfill it in batches and swap the columns",
+	},
+	{
+		match:      regexp.MustCompile(`(?is)^ALTER\s+TABLE\s.*\sADD\s+(CONSTRAINT\s+\S+\s+)?(FOREIGN\s+KEY|CHECK)\s`),
+		exclude:    regexp.MustCompile(`(?is)\sNOT\s+VALID\b`),
+		lock:       "ACCESS EXCLUSIVE",
+		message:    "adding a constraint validates every existing row while holding the lock",
+		suggestion: "add the constraint with NOT VALID, then run ALTER TABLE ... VALIDATE CONSTRAINT separately",
+	},
+	{
+		match:      regexp.MustCompile(`(?is)^ALTER\s+TABLE\s.*\sADD\s+(CONSTRAINT\s+\S+\s+)?(UNIQUE|PRIMARY\s+KEY)\b`),
+		exclude:    regexp.MustCompile(`(?is)\sUSING\s+INDEX\s`),
+		lock:       "ACCESS EXCLUSIVE",
+		message:    "adding a unique or primary key constraint builds an index while holding the lock",
+		suggestion: "create a unique index CONCURRENTLY, then add the constraint USING INDEX",
+	},
+	{
+		match:      regexp.MustCompile(`(?is)^(VACUUM\s+FULL|CLUSTER|REINDEX)\b`),
+		exclude:    regexp.MustCompile(`(?is)\sCONCURRENTLY\b`),
+		lock:       "ACCESS EXCLUSIVE",
+		message:    "this statement rewrites the table while holding the lock",
+		suggestion: "use pg_repack or REINDEX CONCURRENTLY outside of a migration",
+	},
+	{
+		match:      regexp.MustCompile(`(?is)^LOCK\s+(TABLE\s+)?`),
+		lock:       "ACCESS EXCLUSIVE",
+		message:    "explicit LOCK TABLE defaults to ACCESS EXCLUSIVE mode",
+		suggestion: "use the weakest lock mode the migration needs and set a lock_timeout",
+	},
+}
+
+// CockroachDB builds indexes and validates constraints online, only the
+// schema changes that rewrite data in place hold writes back
+var cockroachLockRules = []lockRule{
+	{
+		match:      regexp.MustCompile(`(?is)^ALTER\s+TABLE\s.*\sALTER\s+(COLUMN\s+)?\S+\s+(SET\s+DATA\s+)?TYPE\s`),
+		lock:       "WRITE",
+		message:    "a column type change that rewrites the data makes the column read-only until it completes",
+		suggestion: "add a new column, backfill it in batches and swap the columns",
+	},
+	{
+		match:      regexp.MustCompile(`(?is)^ALTER\s+TABLE\s.*\sALTER\s+PRIMARY\s+KEY\s`),
+		lock:       "SCHEMA",
+		message:    "changing the primary key rebuilds the table and every index, other schema changes to it wait meanwhile",
+		suggestion: "run it in a migration of its own while the traffic is low",
+	},
+	{
+		match:      regexp.MustCompile(`(?is)^TRUNCATE\b`),
+		lock:       "SCHEMA",
+		message:    "TRUNCATE replaces the table with a new one, concurrent transactions on it are aborted",
+		suggestion: "delete the rows in batches if the table is in use",
+	},
+}
+
+// Opening tag of a dollar-quoted string such as $$ or $body$
+var sqlDollarQuoteRegexp = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// SplitSqlStatements splits a migration into its statements. Comments are
+// stripped and statements are split on semicolons, both only outside of
+// quotes and dollar-quoted bodies. Backslashes escape quotes in E'...' strings.
+func SplitSqlStatements(content string) []string {
+	statements := []string{}
+	var current strings.Builder
+	var quote byte
+	var isEscapeString bool
+	var dollarTag string
+
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+
+		switch {
+		case dollarTag != "":
+			if strings.HasPrefix(content[i:], dollarTag) {
+				current.WriteString(dollarTag)
+				i += len(dollarTag) - 1
+				dollarTag = ""
+				continue
+			}
+		case quote != 0:
+			if isEscapeString && c == '\\' && i+1 < len(content) {
+				current.WriteByte(c)
+				i++
+				c = content[i]
+			} else if c == quote {
+				// A doubled quote closes and reopens the string, which keeps it whole
+				quote = 0
+				isEscapeString = false
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			isEscapeString = c == '\'' && i > 0 && (content[i-1] == 'E' || content[i-1] == 'e') &&
+				(i == 1 || !isSqlIdentifierByte(content[i-2]))
+		case c == '-' && strings.HasPrefix(content[i:], "--"):
+			// The newline ending the comment is kept to separate the tokens around it
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+			i--
+			continue
+		case c == '/' && strings.HasPrefix(content[i:], "/*"):
+			i = skipSqlBlockComment(content, i)
+			current.WriteByte(' ')
+			continue
+		case c == '$' && (i == 0 || !isSqlIdentifierByte(content[i-1])):
+			if tag := sqlDollarQuoteRegexp.FindString(content[i:]); tag != "" {
+				dollarTag = tag
+				current.WriteString(tag)
+				i += len(tag) - 1
+				continue
+			}
+		case c == ';':
+			if stmt := strings.TrimSpace(current.String()); stmt != "" {
+				statements = append(statements, stmt)
+			}
+			current.Reset()
+			continue
+		}
+
+		current.WriteByte(c)
+	}
+
+	if stmt := strings.TrimSpace(current.String()); stmt != "" {
+		statements = append(statements, stmt)
+	}
+
+	return statements
+}
+
+// skipSqlBlockComment returns the index of the last byte of the block comment
+// starting at start. Block comments nest as they do in PostgreSQL.
+func skipSqlBlockComment(content string, start int) int {
+	depth := 0
+
+	for i := start; i < len(content)-1; i++ {
+		switch content[i : i+2] {
+		case "/*":
+			depth++
+			i++
+		case "*/":
+			depth--
+			i++
+
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return len(content) - 1
+}
+
+func isSqlIdentifierByte(c byte) bool {
+	return c == '_' || c == '$' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// CheckMigrationLocks returns a hint for every statement of the migration
+// that takes a heavy lock on the given dialect.
+func CheckMigrationLocks(dialect string, migrationKey string, content string) []types.LockHint {
+	hints := []types.LockHint{}
+
+	var rules []lockRule
+
+	switch dialect {
+	case "postgres":
+		rules = postgresLockRules
+	case "cockroach":
+		rules = cockroachLockRules
+	default:
+		return hints
+	}
+
+	for _, statement := range SplitSqlStatements(content) {
+		// Pad the statement so the rules can match keywords on word boundaries
+		padded := statement + " "
+
+		for _, rule := range rules {
+			if !rule.match.MatchString(padded) {
+				continue
+			}
+
+			if rule.exclude != nil && rule.exclude.MatchString(padded) {
+				continue
+			}
+
+			hints = append(hints, types.LockHint{
+				Key:        migrationKey,
+				Statement:  statement,
+				Lock:       rule.lock,
+				Message:    rule.message,
+				Suggestion: rule.suggestion,
+			})
+		}
+	}
+
+	return hints
+}
+
+// GetSessionTimeoutStatements returns the statements that set the lock and
+// statement timeouts for the current session.
+func GetSessionTimeoutStatements(dialect, lockTimeout, statementTimeout string) []string {
+	statements := []string{}
+
+	if dialect != "postgres" && dialect != "cockroach" {
+		return statements
+	}
+
+	if lockTimeout != "" {
+		statements = append(statements, "SET lock_timeout = '"+strings.ReplaceAll(lockTimeout, "'", "''")+"'")
+	}
+
+	if statementTimeout != "" {
+		statements = append(statements, "SET statement_timeout = '"+strings.ReplaceAll(statementTimeout, "'", "''")+"'")
+	}
+
+	return statements
+}
@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitSqlStatements(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "statements",
+			content: "CREATE TABLE a (id INT);\nCREATE TABLE b (id INT);\n",
+			want:    []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"},
+		},
+		{
+			name:    "no trailing semicolon",
+			content: "SELECT 1; SELECT 2",
+			want:    []string{"SELECT 1", "SELECT 2"},
+		},
+		{
+			name:    "line comments",
+			content: "-- first; not a statement\nSELECT 1; -- trailing;\nSELECT 2;",
+			want:    []string{"SELECT 1", "SELECT 2"},
+		},
+		{
+			name:    "block comments",
+			content: "/* a; b */ SELECT 1;\nSELECT/**/2;",
+			want:    []string{"SELECT 1", "SELECT 2"},
+		},
+		{
+			name:    "nested block comments",
+			content: "/* outer /* inner; */ still comment; */ SELECT 1;",
+			want:    []string{"SELECT 1"},
+		},
+		{
+			name:    "semicolon in literal",
+			content: "INSERT INTO a VALUES ('x;y'); SELECT 1;",
+			want:    []string{"INSERT INTO a VALUES ('x;y')", "SELECT 1"},
+		},
+		{
+			name:    "line comment marker in literal",
+			content: "INSERT INTO a VALUES ('--'); SELECT 1;",
+			want:    []string{"INSERT INTO a VALUES ('--')", "SELECT 1"},
+		},
+		{
+			name:    "block comment in literal",
+			content: "INSERT INTO a VALUES ('/*x*/'); SELECT 1;",
+			want:    []string{"INSERT INTO a VALUES ('/*x*/')", "SELECT 1"},
+		},
+		{
+			name:    "doubled quote in literal",
+			content: "INSERT INTO a VALUES ('it''s; --fine'); SELECT 1;",
+			want:    []string{"INSERT INTO a VALUES ('it''s; --fine')", "SELECT 1"},
+		},
+		{
+			name:    "escaped quote in E string",
+			content: "INSERT INTO a VALUES (E'\\'--'); SELECT 1;",
+			want:    []string{"INSERT INTO a VALUES (E'\\'--')", "SELECT 1"},
+		},
+		{
+			name:    "backslash in standard string",
+			content: "INSERT INTO a VALUES ('C:\\'); SELECT 1;",
+			want:    []string{"INSERT INTO a VALUES ('C:\\')", "SELECT 1"},
+		},
+		{
+			name:    "quoted identifiers",
+			content: "CREATE TABLE \"a;--b\" (id INT); CREATE TABLE `c;d` (id INT);",
+			want:    []string{"CREATE TABLE \"a;--b\" (id INT)", "CREATE TABLE `c;d` (id INT)"},
+		},
+		{
+			name:    "dollar-quoted body",
+			content: "CREATE FUNCTION f() RETURNS INT AS $$ SELECT 1; -- kept\n/* kept */ $$ LANGUAGE sql; SELECT 2;",
+			want: []string{
+				"CREATE FUNCTION f() RETURNS INT AS $$ SELECT 1; -- kept\n/* kept */ $$ LANGUAGE sql",
+				"SELECT 2",
+			},
+		},
+		{
+			name:    "tagged dollar-quoted body",
+			content: "DO $body$ BEGIN PERFORM '$$;'; END $body$; SELECT 1;",
+			want:    []string{"DO $body$ BEGIN PERFORM '$$;'; END $body$", "SELECT 1"},
+		},
+		{
+			name:    "positional parameter",
+			content: "PREPARE p AS SELECT $1; SELECT 2;",
+			want:    []string{"PREPARE p AS SELECT $1", "SELECT 2"},
+		},
+		{
+			name:    "only comments",
+			content: "-- nothing\n/* here */\n",
+			want:    []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SplitSqlStatements(tt.content)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitSqlStatements(%q) = %q, want %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckMigrationLocks(t *testing.T) {
+	content := "CREATE INDEX i ON t (x);\nALTER TABLE t ALTER COLUMN x TYPE TEXT;\nTRUNCATE t;"
+
+	tests := []struct {
+		dialect string
+		want    []string
+	}{
+		{"postgres", []string{"SHARE", "ACCESS EXCLUSIVE"}},
+		{"cockroach", []string{"WRITE", "SCHEMA"}},
+		{"mysql", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dialect, func(t *testing.T) {
+			got := []string{}
+
+			for _, hint := range CheckMigrationLocks(tt.dialect, "0001-test", content) {
+				got = append(got, hint.Lock)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CheckMigrationLocks(%q) locks = %q, want %q", tt.dialect, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"database/sql"
+	"database/sql/driver"
+
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// CockroachDB speaks the PostgreSQL protocol, the cockroach driver is pgx
+// under another type so the dialect of a database can be told apart
+type cockroachDriver struct{}
+
+func (cockroachDriver) Open(name string) (driver.Conn, error) {
+	return stdlib.GetDefaultDriver().Open(name)
+}
+
+func init() {
+	sql.Register("cockroach", cockroachDriver{})
+}
+
+// CockroachDB aborts transactions with 40001 under contention and expects the
+// client to retry them, so they are retried even without a retries directive
+const cockroachSerializationRetries = 5
@@ -0,0 +1,192 @@
+//go:build cockroach
+
+package utils
+
+// Run against a disposable CockroachDB with
+// MONARCH_TEST_COCKROACH_URL=postgresql://root@localhost:26257/defaultdb?sslmode=disable go test -tags cockroach ./internal/utils/
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cmseguin/monarch/internal/types"
+)
+
+func openCockroach(t *testing.T) *sql.DB {
+	t.Helper()
+
+	connection := os.Getenv("MONARCH_TEST_COCKROACH_URL")
+
+	if connection == "" {
+		t.Skip("MONARCH_TEST_COCKROACH_URL is not set")
+	}
+
+	db, err := OpenDatabase("cockroach", connection, nil)
+
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	if GetDialect(db) != "cockroach" {
+		t.Fatalf("dialect = %s, want cockroach", GetDialect(db))
+	}
+
+	return db
+}
+
+func mustExec(t *testing.T, db *sql.DB, statement string) {
+	t.Helper()
+
+	if _, err := db.Exec(statement); err != nil {
+		t.Fatalf("%s: %v", statement, err)
+	}
+}
+
+func TestCockroachMigrationLease(t *testing.T) {
+	db := openCockroach(t)
+
+	release, kErr := AcquireMigrationLock(context.Background(), db)
+
+	if kErr != nil {
+		t.Fatalf("acquire: %v", kErr)
+	}
+
+	// A second monarch waits for the lease until its context ends
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if _, kErr := AcquireMigrationLock(ctx, db); kErr == nil {
+		t.Fatal("acquired a lease that is held")
+	}
+
+	release()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	release, kErr = AcquireMigrationLock(ctx, db)
+
+	if kErr != nil {
+		t.Fatalf("acquire after release: %v", kErr)
+	}
+
+	release()
+}
+
+func TestCockroachMigrationLeaseExpiry(t *testing.T) {
+	db := openCockroach(t)
+
+	if kErr := createMigrationLeaseTable(db); kErr != nil {
+		t.Fatalf("create lease table: %v", kErr)
+	}
+
+	// A monarch that crashed leaves its lease behind until it expires
+	mustExec(t, db, "UPSERT INTO migrations_lease (id, owner, expires_at) VALUES (1, 'crashed', now() - '1 second'::INTERVAL)")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	release, kErr := AcquireMigrationLock(ctx, db)
+
+	if kErr != nil {
+		t.Fatalf("acquire expired lease: %v", kErr)
+	}
+
+	release()
+}
+
+func TestCockroachMigrationLeaseRenewal(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for a lease renewal")
+	}
+
+	db := openCockroach(t)
+
+	release, kErr := AcquireMigrationLock(context.Background(), db)
+
+	if kErr != nil {
+		t.Fatalf("acquire: %v", kErr)
+	}
+
+	defer release()
+
+	var before, after time.Time
+
+	if err := db.QueryRow("SELECT expires_at FROM migrations_lease WHERE id = 1").Scan(&before); err != nil {
+		t.Fatalf("read lease: %v", err)
+	}
+
+	time.Sleep(migrationLeaseDuration/3 + 5*time.Second)
+
+	if err := db.QueryRow("SELECT expires_at FROM migrations_lease WHERE id = 1").Scan(&after); err != nil {
+		t.Fatalf("read lease: %v", err)
+	}
+
+	if !after.After(before) {
+		t.Fatalf("lease expiring at %s was not renewed", before)
+	}
+}
+
+func TestCockroachSerializationRetries(t *testing.T) {
+	db := openCockroach(t)
+
+	mustExec(t, db, "DROP SEQUENCE IF EXISTS monarch_test_attempts")
+	mustExec(t, db, "CREATE SEQUENCE monarch_test_attempts")
+	t.Cleanup(func() { db.Exec("DROP SEQUENCE IF EXISTS monarch_test_attempts") })
+
+	// Sequences are not transactional, the first two attempts fail with 40001
+	content := "SELECT CASE WHEN nextval('monarch_test_attempts') < 3 " +
+		"THEN crdb_internal.force_error('40001', 'forced retry') ELSE 0 END;"
+
+	kErr := RunMigration(context.Background(), db, types.MigrationObject{Key: "0001-retry"}, content)
+
+	if kErr != nil {
+		t.Fatalf("run: %v", kErr)
+	}
+
+	var attempts int
+
+	if err := db.QueryRow("SELECT nextval('monarch_test_attempts') - 1").Scan(&attempts); err != nil {
+		t.Fatalf("read attempts: %v", err)
+	}
+
+	if attempts != 3 {
+		t.Fatalf("attempts = %d, want 3", attempts)
+	}
+}
+
+func TestCockroachSchemaChangeSplit(t *testing.T) {
+	db := openCockroach(t)
+
+	mustExec(t, db, "DROP TABLE IF EXISTS monarch_test_split")
+	t.Cleanup(func() { db.Exec("DROP TABLE IF EXISTS monarch_test_split") })
+
+	content := `
+		-- The schema changes run statement by statement
+		CREATE TABLE monarch_test_split (id INT8 PRIMARY KEY, note STRING);
+		INSERT INTO monarch_test_split VALUES (1, 'a;b -- not a comment');
+		/* ; */ ALTER TABLE monarch_test_split ADD COLUMN extra INT8 DEFAULT 7;
+	`
+
+	kErr := RunMigration(context.Background(), db, types.MigrationObject{Key: "0001-split"}, content)
+
+	if kErr != nil {
+		t.Fatalf("run: %v", kErr)
+	}
+
+	var note string
+	var extra int
+
+	if err := db.QueryRow("SELECT note, extra FROM monarch_test_split WHERE id = 1").Scan(&note, &extra); err != nil {
+		t.Fatalf("read row: %v", err)
+	}
+
+	if note != "a;b -- not a comment" || extra != 7 {
+		t.Fatalf("row = (%q, %d), want (%q, 7)", note, extra, "a;b -- not a comment")
+	}
+}
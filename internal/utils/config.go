@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/types"
+)
+
+const configFileName = "monarch.json"
+
+// GetConfigPath returns the path of the config file, which lives next to the
+// migrations directory unless MONARCH_CONFIG points somewhere else.
+func GetConfigPath() (string, *khata.Khata) {
+	if configPath := GetEnv("MONARCH_CONFIG"); configPath != "" {
+		return configPath, nil
+	}
+
+	installDir, kErr := FindInstallationPath()
+
+	// Before init there is no migrations directory yet, use the current one
+	if kErr != nil {
+		currentDir, err := os.Getwd()
+
+		if err != nil {
+			return "", errors.FatalError.Wrap(err).Explain("Could not get current directory")
+		}
+
+		installDir = currentDir
+	}
+
+	return path.Join(installDir, configFileName), nil
+}
+
+// LoadConfig reads the config file. A missing config file is not an error,
+// monarch then runs with an empty config.
+func LoadConfig() (types.Config, *khata.Khata) {
+	config := types.Config{}
+
+	configPath, kErr := GetConfigPath()
+
+	if kErr != nil {
+		return config, kErr
+	}
+
+	content, err := os.ReadFile(configPath)
+
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+
+	if err != nil {
+		return config, errors.FatalError.Wrap(err).Explain("Could not read config file")
+	}
+
+	err = json.Unmarshal(content, &config)
+
+	if err != nil {
+		return config, errors.FatalError.Wrap(err).Explainf("Could not parse config file %s", configPath)
+	}
+
+	return config, nil
+}
+
+// GetEnvironmentConfig returns the section of the config for an environment.
+func GetEnvironmentConfig(config types.Config, env string) types.EnvironmentConfig {
+	if env == "" || config.Environments == nil {
+		return types.EnvironmentConfig{}
+	}
+
+	return config.Environments[env]
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/types"
+	"github.com/spf13/cobra"
+)
+
+// GetRunContext returns the context migrations run with. It is cancelled on
+// SIGINT or SIGTERM, which interrupts the running statement and rolls back
+// its transaction, and when the --timeout of the whole run expires. Create it
+// after the confirmation prompt so Ctrl-C still aborts the prompt.
+func GetRunContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	timeout := GetDurationArg(cmd, "timeout", "MONARCH_TIMEOUT", 0)
+
+	if timeout <= 0 {
+		return ctx, stop
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// SetDefaultMigrationDirectives gives the migrations without a timeout or
+// retries directive the values of the --migration-timeout and --retries flags.
+func SetDefaultMigrationDirectives(cmd *cobra.Command, migrationObjects []types.MigrationObject) []types.MigrationObject {
+	timeout := GetDurationArg(cmd, "migration-timeout", "MONARCH_MIGRATION_TIMEOUT", 0)
+	retries := GetIntArg(cmd, "retries", "MONARCH_RETRIES", 0)
+	defaultedMigrationObjects := append([]types.MigrationObject{}, migrationObjects...)
+
+	for i := range defaultedMigrationObjects {
+		if defaultedMigrationObjects[i].Directives.Timeout == 0 {
+			defaultedMigrationObjects[i].Directives.Timeout = timeout
+		}
+
+		if defaultedMigrationObjects[i].Directives.Retries == 0 {
+			defaultedMigrationObjects[i].Directives.Retries = retries
+		}
+	}
+
+	return defaultedMigrationObjects
+}
+
+// wrapContextError tells whether a statement failed because it was
+// interrupted or timed out.
+func wrapContextError(ctx context.Context, err error, explanation string) *khata.Khata {
+	switch ctx.Err() {
+	case context.Canceled:
+		err = fmt.Errorf("interrupted: %w", err)
+	case context.DeadlineExceeded:
+		err = fmt.Errorf("timed out: %w", err)
+	}
+
+	return errors.FatalError.Wrap(err).Explain(explanation)
+}
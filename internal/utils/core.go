@@ -1,6 +1,9 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
 	"os"
 	"path"
 	"regexp"
@@ -49,11 +52,27 @@ func GetMigrationPath(initPath string) (string, *khata.Khata) {
 	return migrationDir, nil
 }
 
+func GetSeedPath(initPath string) (string, *khata.Khata) {
+	installDir, err := FindInstallationPath()
+
+	if err != nil {
+		return "", errors.FatalError.Wrap(err).Explain("Could not find installation path")
+	}
+
+	seedDir := path.Join(installDir, "seeds")
+
+	return seedDir, nil
+}
+
 func GetMigrationContent(migrationDir, file string) (string, *khata.Khata) {
-	migrationPath := path.Join(migrationDir, file)
+	return GetMigrationContentFromFS(os.DirFS(migrationDir), file)
+}
 
+// GetMigrationContentFromFS reads a migration from a file system such as an
+// embed.FS, which is how the migrator loads migrations in library mode.
+func GetMigrationContentFromFS(fsys fs.FS, file string) (string, *khata.Khata) {
 	// Read the file
-	migrationContent, err := os.ReadFile(migrationPath)
+	migrationContent, err := fs.ReadFile(fsys, file)
 
 	if err != nil {
 		return "", errors.FatalError.Wrap(err).Explain("Could not read migration file")
@@ -114,7 +133,14 @@ func GetDownMigratrionObjectsFromDir(
 	dirname string,
 	migrationObjects *[]types.MigrationObject,
 ) *khata.Khata {
-	entries, err := os.ReadDir(dirname)
+	return GetDownMigrationObjectsFromFS(os.DirFS(dirname), migrationObjects)
+}
+
+func GetDownMigrationObjectsFromFS(
+	fsys fs.FS,
+	migrationObjects *[]types.MigrationObject,
+) *khata.Khata {
+	entries, err := fs.ReadDir(fsys, ".")
 
 	if err != nil {
 		return errors.FatalError.Wrap(err).Explain("Could not read directory")
@@ -125,10 +151,33 @@ func GetDownMigratrionObjectsFromDir(
 			continue
 		}
 
-		if entry.Name()[len(entry.Name())-9:] == ".down.sql" {
+		if strings.HasSuffix(entry.Name(), ".down.sql") {
+			key := strings.TrimSuffix(entry.Name(), ".down.sql")
+
+			directives, kErr := getMigrationFileDirectives(fsys, entry.Name())
+
+			if kErr != nil {
+				return kErr
+			}
+
+			// The up file describes the migration as a whole: an irreversible up
+			// migration cannot be rolled back and its envs and tags apply to both
+			if _, err := fs.Stat(fsys, key+".up.sql"); err == nil {
+				upDirectives, kErr := getMigrationFileDirectives(fsys, key+".up.sql")
+
+				if kErr != nil {
+					return kErr
+				}
+
+				directives.Irreversible = directives.Irreversible || upDirectives.Irreversible
+				directives.Envs = append(directives.Envs, upDirectives.Envs...)
+				directives.Tags = append(directives.Tags, upDirectives.Tags...)
+			}
+
 			*migrationObjects = append(*migrationObjects, types.MigrationObject{
-				Key:  entry.Name()[0 : len(entry.Name())-9],
-				File: entry.Name(),
+				Key:        key,
+				File:       entry.Name(),
+				Directives: directives,
 			})
 		}
 	}
@@ -140,7 +189,14 @@ func GetUpMigratrionObjectsFromDir(
 	dirname string,
 	migrationObjects *[]types.MigrationObject,
 ) *khata.Khata {
-	entries, err := os.ReadDir(dirname)
+	return GetUpMigrationObjectsFromFS(os.DirFS(dirname), migrationObjects)
+}
+
+func GetUpMigrationObjectsFromFS(
+	fsys fs.FS,
+	migrationObjects *[]types.MigrationObject,
+) *khata.Khata {
+	entries, err := fs.ReadDir(fsys, ".")
 
 	if err != nil {
 		return errors.FatalError.Wrap(err).Explain("Could not read directory")
@@ -151,10 +207,17 @@ func GetUpMigratrionObjectsFromDir(
 			continue
 		}
 
-		if entry.Name()[len(entry.Name())-7:] == ".up.sql" {
+		if strings.HasSuffix(entry.Name(), ".up.sql") {
+			directives, kErr := getMigrationFileDirectives(fsys, entry.Name())
+
+			if kErr != nil {
+				return kErr
+			}
+
 			*migrationObjects = append(*migrationObjects, types.MigrationObject{
-				Key:  entry.Name()[0 : len(entry.Name())-7],
-				File: entry.Name(),
+				Key:        strings.TrimSuffix(entry.Name(), ".up.sql"),
+				File:       entry.Name(),
+				Directives: directives,
 			})
 		}
 	}
@@ -162,11 +225,145 @@ func GetUpMigratrionObjectsFromDir(
 	return nil
 }
 
+func GetSeedObjectsFromDir(
+	dirname string,
+	seedObjects *[]types.MigrationObject,
+) *khata.Khata {
+	fsys := os.DirFS(dirname)
+	entries, err := fs.ReadDir(fsys, ".")
+
+	if err != nil {
+		return errors.FatalError.Wrap(err).Explain("Could not read directory")
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		if strings.HasSuffix(entry.Name(), ".sql") {
+			directives, kErr := getMigrationFileDirectives(fsys, entry.Name())
+
+			if kErr != nil {
+				return kErr
+			}
+
+			*seedObjects = append(*seedObjects, types.MigrationObject{
+				Key:        strings.TrimSuffix(entry.Name(), ".sql"),
+				File:       entry.Name(),
+				Directives: directives,
+			})
+		}
+	}
+
+	return nil
+}
+
+// GetRepeatableMigrationObjectsFromDir loads the R-<name>.sql migrations that
+// are re-applied whenever their rendered content changes.
+func GetRepeatableMigrationObjectsFromDir(
+	dirname string,
+	vars map[string]string,
+	migrationObjects *[]types.MigrationObject,
+) *khata.Khata {
+	return GetRepeatableMigrationObjectsFromFS(os.DirFS(dirname), vars, migrationObjects)
+}
+
+func GetRepeatableMigrationObjectsFromFS(
+	fsys fs.FS,
+	vars map[string]string,
+	migrationObjects *[]types.MigrationObject,
+) *khata.Khata {
+	entries, err := fs.ReadDir(fsys, ".")
+
+	if err != nil {
+		return errors.FatalError.Wrap(err).Explain("Could not read directory")
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		if strings.HasPrefix(entry.Name(), "R-") && strings.HasSuffix(entry.Name(), ".sql") {
+			content, kErr := GetMigrationContentFromFS(fsys, entry.Name())
+
+			if kErr != nil {
+				return kErr
+			}
+
+			directives, kErr := ParseMigrationDirectives(content)
+
+			if kErr != nil {
+				return kErr.Explainf("Could not parse the directives of %s", entry.Name())
+			}
+
+			// A change of the variables a migration uses re-applies it too
+			rendered, kErr := RenderMigration(content, vars)
+
+			if kErr != nil {
+				return kErr.Explainf("Could not render migration %s", entry.Name())
+			}
+
+			*migrationObjects = append(*migrationObjects, types.MigrationObject{
+				Key:        strings.TrimSuffix(entry.Name(), ".sql"),
+				File:       entry.Name(),
+				Checksum:   ComputeChecksum(rendered),
+				Directives: directives,
+			})
+		}
+	}
+
+	return nil
+}
+
+// ComputeChecksum returns the hex encoded sha256 of a migration's content.
+func ComputeChecksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// FilterStaleRepeatableMigrations returns the repeatable migrations whose
+// checksum differs from the one recorded when they were last applied.
+func FilterStaleRepeatableMigrations(
+	migrationObjects []types.MigrationObject,
+	migrationsFromDbMap map[string]types.Migration,
+) []types.MigrationObject {
+	staleMigrationObjects := []types.MigrationObject{}
+
+	for _, migrationObject := range migrationObjects {
+		migration, ok := migrationsFromDbMap[migrationObject.Key]
+
+		if !ok || !migration.IsApplied || migration.Checksum != migrationObject.Checksum {
+			staleMigrationObjects = append(staleMigrationObjects, migrationObject)
+		}
+	}
+
+	return staleMigrationObjects
+}
+
+func getMigrationFileDirectives(fsys fs.FS, file string) (types.MigrationDirectives, *khata.Khata) {
+	content, kErr := GetMigrationContentFromFS(fsys, file)
+
+	if kErr != nil {
+		return types.MigrationDirectives{}, kErr
+	}
+
+	directives, kErr := ParseMigrationDirectives(content)
+
+	if kErr != nil {
+		return directives, kErr.Explainf("Could not parse the directives of %s", file)
+	}
+
+	return directives, nil
+}
+
 func SortMigrationObjects(migrationObjects []types.MigrationObject) []types.MigrationObject {
 	sortedMigrationObjects := append([]types.MigrationObject{}, migrationObjects...)
 
-	sort.Slice(sortedMigrationObjects, func(i, j int) bool {
-		return sortedMigrationObjects[i].Key < sortedMigrationObjects[j].Key
+	sort.SliceStable(sortedMigrationObjects, func(i, j int) bool {
+		return CompareMigrationKeys(sortedMigrationObjects[i].Key, sortedMigrationObjects[j].Key) < 0
 	})
 
 	return sortedMigrationObjects
@@ -0,0 +1,273 @@
+package utils
+
+import (
+	"database/sql"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/go-sql-driver/mysql"
+)
+
+// The database every PostgreSQL server has, used to create and drop the
+// others
+const postgresMaintenanceDatabase = "postgres"
+
+// The database a CockroachDB cluster starts with
+const cockroachMaintenanceDatabase = "defaultdb"
+
+var keyValueDbnameRegexp = regexp.MustCompile(`(^|\s)dbname\s*=\s*('(?:[^'\\]|\\.)*'|\S+)`)
+
+// GetDatabaseName returns the name of the database a connection string
+// points to, the file path for SQLite.
+func GetDatabaseName(driver string, connection string) (string, *khata.Khata) {
+	var name string
+
+	switch GetDriverDialect(driver) {
+	case "mysql":
+		config, err := mysql.ParseDSN(connection)
+
+		if err != nil {
+			return "", errors.FatalError.Wrap(err).Explain("Could not parse MySQL connection string")
+		}
+
+		name = config.DBName
+	case "postgres", "cockroach":
+		if strings.HasPrefix(connection, "postgres://") || strings.HasPrefix(connection, "postgresql://") {
+			u, err := url.Parse(connection)
+
+			if err != nil {
+				return "", errors.FatalError.Wrap(err).Explain("Could not parse PostgreSQL connection URL")
+			}
+
+			name = strings.TrimPrefix(u.Path, "/")
+		} else if match := keyValueDbnameRegexp.FindStringSubmatch(connection); match != nil {
+			name = strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(strings.Trim(match[2], "'"))
+		}
+	case "sqlite":
+		name = strings.TrimPrefix(connection, "file:")
+
+		if i := strings.Index(name, "?"); i != -1 {
+			name = name[:i]
+		}
+
+		if name == ":memory:" {
+			return "", errors.FatalError.New("an in-memory sqlite database cannot be created or dropped")
+		}
+	default:
+		return "", errors.FatalError.New("creating a database is not supported by driver " + driver)
+	}
+
+	if name == "" {
+		return "", errors.FatalError.New("the connection string has no database name")
+	}
+
+	return name, nil
+}
+
+// GetMaintenanceConnection returns a connection string to the same server
+// that does not depend on the database existing: the postgres database for
+// PostgreSQL, no database for MySQL.
+func GetMaintenanceConnection(driver string, connection string) (string, *khata.Khata) {
+	switch GetDriverDialect(driver) {
+	case "mysql":
+		config, err := mysql.ParseDSN(connection)
+
+		if err != nil {
+			return "", errors.FatalError.Wrap(err).Explain("Could not parse MySQL connection string")
+		}
+
+		config.DBName = ""
+
+		return config.FormatDSN(), nil
+	case "postgres", "cockroach":
+		maintenanceDatabase := postgresMaintenanceDatabase
+
+		if GetDriverDialect(driver) == "cockroach" {
+			maintenanceDatabase = cockroachMaintenanceDatabase
+		}
+
+		if strings.HasPrefix(connection, "postgres://") || strings.HasPrefix(connection, "postgresql://") {
+			u, err := url.Parse(connection)
+
+			if err != nil {
+				return "", errors.FatalError.Wrap(err).Explain("Could not parse PostgreSQL connection URL")
+			}
+
+			u.Path = "/" + maintenanceDatabase
+			u.RawPath = ""
+
+			return u.String(), nil
+		}
+
+		return keyValueDbnameRegexp.ReplaceAllString(connection, "${1}dbname="+maintenanceDatabase), nil
+	}
+
+	return "", errors.FatalError.New("no maintenance database for driver " + driver)
+}
+
+// DatabaseExists tells whether the server behind a maintenance connection has
+// a database.
+func DatabaseExists(db *sql.DB, name string) (bool, *khata.Khata) {
+	var query string
+
+	switch GetDialect(db) {
+	case "mysql":
+		query = "SELECT 1 FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = ?"
+	case "postgres", "cockroach":
+		query = "SELECT 1 FROM pg_database WHERE datname = $1"
+	default:
+		return false, errors.FatalError.New("unsupported dialect: " + GetDialect(db))
+	}
+
+	rows, err := db.Query(query, name)
+
+	if err != nil {
+		return false, errors.FatalError.Wrap(err).Explain("Could not check if the database exists")
+	}
+
+	defer rows.Close()
+
+	return rows.Next(), nil
+}
+
+// CreateDatabase creates a database on the server behind a maintenance
+// connection. The encoding and the collation are optional.
+func CreateDatabase(db *sql.DB, name string, encoding string, collation string) *khata.Khata {
+	var statement string
+
+	switch GetDialect(db) {
+	case "mysql":
+		statement = "CREATE DATABASE IF NOT EXISTS " + quoteMysqlIdentifier(name)
+
+		if encoding != "" {
+			statement += " CHARACTER SET " + quoteStringLiteral(encoding)
+		}
+
+		if collation != "" {
+			statement += " COLLATE " + quoteStringLiteral(collation)
+		}
+	case "postgres":
+		// PostgreSQL has no IF NOT EXISTS for databases, the caller checks
+		statement = "CREATE DATABASE " + quotePostgresIdentifier(name)
+
+		if encoding != "" {
+			statement += " ENCODING " + quoteStringLiteral(encoding)
+		}
+
+		if collation != "" {
+			statement += " LC_COLLATE " + quoteStringLiteral(collation)
+		}
+
+		// template1 may have another encoding or collation, template0 accepts any
+		if encoding != "" || collation != "" {
+			statement += " TEMPLATE template0"
+		}
+	case "cockroach":
+		statement = "CREATE DATABASE IF NOT EXISTS " + quotePostgresIdentifier(name)
+
+		if encoding != "" {
+			statement += " ENCODING = " + quoteStringLiteral(encoding)
+		}
+
+		// CockroachDB only has the C collation at the database level
+		if collation != "" {
+			return errors.FatalError.New("a database collation is not supported by cockroach, collate the columns instead")
+		}
+	default:
+		return errors.FatalError.New("unsupported dialect: " + GetDialect(db))
+	}
+
+	_, err := db.Exec(statement)
+
+	if err != nil {
+		return errors.FatalError.Wrap(err).Explainf("Could not create database %s", name)
+	}
+
+	return nil
+}
+
+// DropDatabase drops a database of the server behind a maintenance
+// connection if it exists.
+func DropDatabase(db *sql.DB, name string) *khata.Khata {
+	var statement string
+
+	switch GetDialect(db) {
+	case "mysql":
+		statement = "DROP DATABASE IF EXISTS " + quoteMysqlIdentifier(name)
+	case "postgres", "cockroach":
+		statement = "DROP DATABASE IF EXISTS " + quotePostgresIdentifier(name)
+	default:
+		return errors.FatalError.New("unsupported dialect: " + GetDialect(db))
+	}
+
+	_, err := db.Exec(statement)
+
+	if err != nil {
+		return errors.FatalError.Wrap(err).Explainf("Could not drop database %s", name)
+	}
+
+	return nil
+}
+
+// CreateSQLiteDatabase creates an empty SQLite database file, which SQLite
+// fills in on the first write. It returns false if the file already exists.
+func CreateSQLiteDatabase(databasePath string) (bool, *khata.Khata) {
+	if _, err := os.Stat(databasePath); err == nil {
+		return false, nil
+	}
+
+	err := os.MkdirAll(path.Dir(databasePath), 0755)
+
+	if err != nil {
+		return false, errors.FatalError.Wrap(err).Explain("Could not create the database directory")
+	}
+
+	file, err := os.OpenFile(databasePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return false, errors.FatalError.Wrap(err).Explainf("Could not create database %s", databasePath)
+	}
+
+	err = file.Close()
+
+	if err != nil {
+		return false, errors.FatalError.Wrap(err).Explainf("Could not create database %s", databasePath)
+	}
+
+	return true, nil
+}
+
+// DropSQLiteDatabase deletes a SQLite database file with its journal and WAL
+// files. It returns false if the file does not exist.
+func DropSQLiteDatabase(databasePath string) (bool, *khata.Khata) {
+	if _, err := os.Stat(databasePath); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+		err := os.Remove(databasePath + suffix)
+
+		if err != nil && !os.IsNotExist(err) {
+			return false, errors.FatalError.Wrap(err).Explainf("Could not delete %s", databasePath+suffix)
+		}
+	}
+
+	return true, nil
+}
+
+func quotePostgresIdentifier(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+func quoteMysqlIdentifier(identifier string) string {
+	return "`" + strings.ReplaceAll(identifier, "`", "``") + "`"
+}
+
+func quoteStringLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
@@ -1,12 +1,16 @@
 package utils
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"time"
 
 	"github.com/cmseguin/khata"
 	"github.com/cmseguin/monarch/internal/errors"
 	"github.com/cmseguin/monarch/internal/types"
 	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/lib/pq"
 	"github.com/spf13/cobra"
 	"modernc.org/sqlite"
@@ -25,19 +29,39 @@ func IsDriverSupported(driver string, supportedDriverList []string) bool {
 }
 
 func ConnectToDatabase(driver string, connection string) (*sql.DB, *khata.Khata) {
-	db, err := sql.Open(driver, connection)
+	return WaitForDatabase(driver, connection, nil, 0)
+}
+
+// WaitForDatabase connects to the database, retrying with an exponential
+// backoff until it answers or the wait is over. Databases started alongside
+// monarch, in docker-compose or Kubernetes, often need a few seconds. The
+// session statements run on every connection the pool opens.
+func WaitForDatabase(driver string, connection string, sessionStatements []string, wait time.Duration) (*sql.DB, *khata.Khata) {
+	db, err := OpenDatabase(driver, connection, sessionStatements)
 
 	if err != nil {
 		return nil, errors.FatalError.Wrap(err).Explain("Could not connect to database")
 	}
 
-	err = db.Ping()
+	deadline := time.Now().Add(wait)
 
-	if err != nil {
-		return nil, errors.FatalError.Wrap(err).Explain("Could not ping database")
-	}
+	for attempt := 0; ; attempt++ {
+		err = db.Ping()
+
+		if err == nil {
+			return db, nil
+		}
+
+		delay := getRetryDelay(attempt)
+
+		if time.Now().Add(delay).After(deadline) {
+			db.Close()
+			return nil, errors.FatalError.Wrap(err).Explain("Could not ping database")
+		}
 
-	return db, nil
+		PrintWarning(fmt.Sprintf("Database is not ready, retrying in %s", delay))
+		time.Sleep(delay)
+	}
 }
 
 func CreateMigrationTable(db *sql.DB) *khata.Khata {
@@ -51,18 +75,43 @@ func CreateMigrationTable(db *sql.DB) *khata.Khata {
 					id INT NOT NULL AUTO_INCREMENT, 
 					key VARCHAR(255) NOT NULL,
 					is_applied BOOLEAN NOT NULL DEFAULT FALSE,
+					applied_filter VARCHAR(255) NOT NULL DEFAULT '',
+					checksum VARCHAR(64) NOT NULL DEFAULT '',
+					batch INTEGER NOT NULL DEFAULT 0,
+					state VARCHAR(16) NOT NULL DEFAULT 'pending',
 					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 					updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 					PRIMARY KEY (id)
 				)
 			`)
-	case *pq.Driver:
+	case cockroachDriver:
+		// SERIAL is unique_rowid() on CockroachDB too, spelled out so the
+		// column does not depend on the serial_normalization setting
+		_, err = db.Exec(`
+				CREATE TABLE IF NOT EXISTS migrations
+				(
+					id INT8 PRIMARY KEY DEFAULT unique_rowid(),
+					key VARCHAR(255) NOT NULL,
+					is_applied BOOLEAN NOT NULL DEFAULT FALSE,
+					applied_filter VARCHAR(255) NOT NULL DEFAULT '',
+					checksum VARCHAR(64) NOT NULL DEFAULT '',
+					batch INT8 NOT NULL DEFAULT 0,
+					state VARCHAR(16) NOT NULL DEFAULT 'pending',
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+					updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+				)
+			`)
+	case *pq.Driver, *stdlib.Driver:
 		_, err = db.Exec(`
 				CREATE TABLE IF NOT EXISTS migrations
 				(
 					id SERIAL PRIMARY KEY,
 					key VARCHAR(255) NOT NULL,
 					is_applied BOOLEAN NOT NULL DEFAULT FALSE,
+					applied_filter VARCHAR(255) NOT NULL DEFAULT '',
+					checksum VARCHAR(64) NOT NULL DEFAULT '',
+					batch INTEGER NOT NULL DEFAULT 0,
+					state VARCHAR(16) NOT NULL DEFAULT 'pending',
 					created_at TIMESTAMP NOT NULL DEFAULT NOW(),
 					updated_at TIMESTAMP NOT NULL DEFAULT NOW()
 				)
@@ -74,6 +123,10 @@ func CreateMigrationTable(db *sql.DB) *khata.Khata {
 					id INTEGER PRIMARY KEY AUTOINCREMENT,
 					key VARCHAR(255) NOT NULL,
 					is_applied BOOLEAN NOT NULL DEFAULT FALSE,
+					applied_filter VARCHAR(255) NOT NULL DEFAULT '',
+					checksum VARCHAR(64) NOT NULL DEFAULT '',
+					batch INTEGER NOT NULL DEFAULT 0,
+					state VARCHAR(16) NOT NULL DEFAULT 'pending',
 					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 					updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 				)
@@ -84,17 +137,174 @@ func CreateMigrationTable(db *sql.DB) *khata.Khata {
 		return errors.FatalError.Wrap(err).Explain("Could not create migrations table")
 	}
 
+	return UpgradeMigrationTable(db)
+}
+
+// UpgradeMigrationTable adds the columns introduced by newer versions of
+// monarch to a migrations table created by an older version.
+func UpgradeMigrationTable(db *sql.DB) *khata.Khata {
+	columns := []struct {
+		name       string
+		definition string
+		backfill   string
+	}{
+		{"applied_filter", "VARCHAR(255) NOT NULL DEFAULT ''", ""},
+		{"checksum", "VARCHAR(64) NOT NULL DEFAULT ''", ""},
+		// Migrations applied before batches were recorded get a batch each, in
+		// the order they were applied, so down does not roll back all of them
+		{"batch", "INTEGER NOT NULL DEFAULT 0", `
+			UPDATE migrations SET batch = (
+				SELECT COUNT(*) FROM (SELECT id FROM migrations WHERE is_applied = true) AS applied
+				WHERE applied.id <= migrations.id
+			)
+			WHERE is_applied = true
+		`},
+		{"state", "VARCHAR(16) NOT NULL DEFAULT 'pending'", "UPDATE migrations SET state = 'applied' WHERE is_applied = true"},
+	}
+
+	for _, column := range columns {
+		// Selecting the column fails when it does not exist yet
+		rows, err := db.Query("SELECT " + column.name + " FROM migrations WHERE 1 = 0")
+
+		if err == nil {
+			rows.Close()
+			continue
+		}
+
+		_, err = db.Exec("ALTER TABLE migrations ADD COLUMN " + column.name + " " + column.definition)
+
+		if err != nil {
+			return errors.FatalError.Wrap(err).Explainf("Could not add column %s to migrations table", column.name)
+		}
+
+		if column.backfill == "" {
+			continue
+		}
+
+		_, err = db.Exec(column.backfill)
+
+		if err != nil {
+			return errors.FatalError.Wrap(err).Explainf("Could not fill column %s of migrations table", column.name)
+		}
+	}
+
 	return nil
 }
 
-func CreateMigrationEntry(db *sql.DB, name string) *khata.Khata {
+func CreateSeedTable(db *sql.DB) *khata.Khata {
 	var err error
 
 	switch db.Driver().(type) {
 	case *mysql.MySQLDriver:
+		_, err = db.Exec(`
+				CREATE TABLE IF NOT EXISTS seeds
+				(
+					id INT NOT NULL AUTO_INCREMENT,
+					key VARCHAR(255) NOT NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+					PRIMARY KEY (id)
+				)
+			`)
+	case cockroachDriver:
+		_, err = db.Exec(`
+				CREATE TABLE IF NOT EXISTS seeds
+				(
+					id INT8 PRIMARY KEY DEFAULT unique_rowid(),
+					key VARCHAR(255) NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+					updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+				)
+			`)
+	case *pq.Driver, *stdlib.Driver:
+		_, err = db.Exec(`
+				CREATE TABLE IF NOT EXISTS seeds
+				(
+					id SERIAL PRIMARY KEY,
+					key VARCHAR(255) NOT NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+					updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+				)
+			`)
 	case *sqlite.Driver:
+		_, err = db.Exec(`
+				CREATE TABLE IF NOT EXISTS seeds
+				(
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					key VARCHAR(255) NOT NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)
+			`)
+	}
+
+	if err != nil {
+		return errors.FatalError.Wrap(err).Explain("Could not create seeds table")
+	}
+
+	return nil
+}
+
+func GetSeedsFromDatabase(db *sql.DB) ([]string, *khata.Khata) {
+	var seeds []string
+
+	rows, err := db.Query("SELECT key FROM seeds")
+
+	if err != nil {
+		return nil, errors.FatalError.Wrap(err).Explain("Could not get seeds from database")
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+
+		err = rows.Scan(&name)
+
+		if err != nil {
+			return nil, errors.FatalError.Wrap(err).Explain("Could not scan seed row")
+		}
+
+		seeds = append(seeds, name)
+	}
+
+	return seeds, nil
+}
+
+// RecordSeed stores that a seed ran. Re-runnable seeds keep a single row
+// whose updated_at reflects the last run.
+func RecordSeed(db *sql.DB, name string, exists bool) *khata.Khata {
+	var err error
+
+	switch db.Driver().(type) {
+	case *mysql.MySQLDriver, *sqlite.Driver:
+		if exists {
+			_, err = db.Exec("UPDATE seeds SET updated_at = CURRENT_TIMESTAMP WHERE key = ?", name)
+		} else {
+			_, err = db.Exec("INSERT INTO seeds (key) VALUES (?)", name)
+		}
+	case *pq.Driver, *stdlib.Driver, cockroachDriver:
+		if exists {
+			_, err = db.Exec("UPDATE seeds SET updated_at = CURRENT_TIMESTAMP WHERE key = $1", name)
+		} else {
+			_, err = db.Exec("INSERT INTO seeds (key) VALUES ($1)", name)
+		}
+	}
+
+	if err != nil {
+		return errors.FatalError.Wrap(err).Explain("Could not record seed")
+	}
+
+	return nil
+}
+
+func CreateMigrationEntry(db *sql.DB, name string) *khata.Khata {
+	var err error
+
+	switch db.Driver().(type) {
+	case *mysql.MySQLDriver, *sqlite.Driver:
 		_, err = db.Exec("INSERT INTO migrations (key) VALUES (?)", name)
-	case *pq.Driver:
+	case *pq.Driver, *stdlib.Driver, cockroachDriver:
 		_, err = db.Exec("INSERT INTO migrations (key) VALUES ($1)", name)
 	}
 
@@ -105,15 +315,14 @@ func CreateMigrationEntry(db *sql.DB, name string) *khata.Khata {
 	return nil
 }
 
-func ApplyMigration(db *sql.DB, name string) *khata.Khata {
+func ApplyMigration(db *sql.DB, name string, filter string, batch int) *khata.Khata {
 	var err error
 
 	switch db.Driver().(type) {
-	case *mysql.MySQLDriver:
-	case *sqlite.Driver:
-		_, err = db.Exec("UPDATE migrations SET is_applied = true, updated_at = NOW() WHERE key = ?", name)
-	case *pq.Driver:
-		_, err = db.Exec("UPDATE migrations SET is_applied = true, updated_at = NOW() WHERE key = $1", name)
+	case *mysql.MySQLDriver, *sqlite.Driver:
+		_, err = db.Exec("UPDATE migrations SET is_applied = true, state = 'applied', applied_filter = ?, batch = ?, updated_at = CURRENT_TIMESTAMP WHERE key = ?", filter, batch, name)
+	case *pq.Driver, *stdlib.Driver, cockroachDriver:
+		_, err = db.Exec("UPDATE migrations SET is_applied = true, state = 'applied', applied_filter = $1, batch = $2, updated_at = CURRENT_TIMESTAMP WHERE key = $3", filter, batch, name)
 	}
 
 	if err != nil {
@@ -123,14 +332,106 @@ func ApplyMigration(db *sql.DB, name string) *khata.Khata {
 	return nil
 }
 
+func SetMigrationChecksum(db *sql.DB, name string, checksum string) *khata.Khata {
+	var err error
+
+	switch db.Driver().(type) {
+	case *mysql.MySQLDriver, *sqlite.Driver:
+		_, err = db.Exec("UPDATE migrations SET checksum = ? WHERE key = ?", checksum, name)
+	case *pq.Driver, *stdlib.Driver, cockroachDriver:
+		_, err = db.Exec("UPDATE migrations SET checksum = $1 WHERE key = $2", checksum, name)
+	}
+
+	if err != nil {
+		return errors.FatalError.Wrap(err).Explain("Could not set migration checksum")
+	}
+
+	return nil
+}
+
+// SetMigrationState records the state of a migration without changing
+// whether it is applied, which is how running and failed migrations are
+// tracked.
+func SetMigrationState(db *sql.DB, name string, state string) *khata.Khata {
+	var err error
+
+	switch db.Driver().(type) {
+	case *mysql.MySQLDriver, *sqlite.Driver:
+		_, err = db.Exec("UPDATE migrations SET state = ?, updated_at = CURRENT_TIMESTAMP WHERE key = ?", state, name)
+	case *pq.Driver, *stdlib.Driver, cockroachDriver:
+		_, err = db.Exec("UPDATE migrations SET state = $1, updated_at = CURRENT_TIMESTAMP WHERE key = $2", state, name)
+	}
+
+	if err != nil {
+		return errors.FatalError.Wrap(err).Explain("Could not set migration state")
+	}
+
+	return nil
+}
+
+// CheckDirtyMigrations refuses to go on while a migration is running or
+// failed, since the database may be left halfway through it.
+func CheckDirtyMigrations(migrations []types.Migration) *khata.Khata {
+	dirtyMigrationKeys := []string{}
+
+	for _, migration := range migrations {
+		if migration.State == types.MigrationStateRunning || migration.State == types.MigrationStateFailed {
+			dirtyMigrationKeys = append(dirtyMigrationKeys, migration.Key+" ("+migration.State+")")
+		}
+	}
+
+	if len(dirtyMigrationKeys) == 0 {
+		return nil
+	}
+
+	return errors.FatalError.New(
+		"the database is dirty, fix it by hand then run `monarch force <key> --state applied|pending`",
+		SPrintUnorderedList(dirtyMigrationKeys),
+	)
+}
+
+// GetFailedMigrationState returns the state to record when a migration fails.
+// A migration rolled back with its transaction leaves the database as it was,
+// anything else may have been partially applied.
+func GetFailedMigrationState(db *sql.DB, migrationObject types.MigrationObject, content string, previousState string) string {
+	// MySQL commits implicitly around DDL statements and CockroachDB runs them
+	// one by one, either may have left part of the migration applied
+	if migrationObject.Directives.NoTransaction || GetDialect(db) == "mysql" {
+		return types.MigrationStateFailed
+	}
+
+	if GetDialect(db) == "cockroach" && containsSchemaChange(content) {
+		return types.MigrationStateFailed
+	}
+
+	if previousState == "" {
+		return types.MigrationStatePending
+	}
+
+	return previousState
+}
+
+// GetNextMigrationBatch returns the batch number for the migrations applied
+// by a new up run.
+func GetNextMigrationBatch(db *sql.DB) (int, *khata.Khata) {
+	var batch int
+
+	err := db.QueryRow("SELECT COALESCE(MAX(batch), 0) + 1 FROM migrations").Scan(&batch)
+
+	if err != nil {
+		return 0, errors.FatalError.Wrap(err).Explain("Could not get next migration batch")
+	}
+
+	return batch, nil
+}
+
 func RollbackMigration(db *sql.DB, name string) *khata.Khata {
 	var err error
 	switch db.Driver().(type) {
-	case *mysql.MySQLDriver:
-	case *sqlite.Driver:
-		_, err = db.Exec("UPDATE migrations SET is_applied = false, updated_at = NOW() WHERE key = ?", name)
-	case *pq.Driver:
-		_, err = db.Exec("UPDATE migrations SET is_applied = false, updated_at = NOW() WHERE key = $1", name)
+	case *mysql.MySQLDriver, *sqlite.Driver:
+		_, err = db.Exec("UPDATE migrations SET is_applied = false, state = 'pending', batch = 0, updated_at = CURRENT_TIMESTAMP WHERE key = ?", name)
+	case *pq.Driver, *stdlib.Driver, cockroachDriver:
+		_, err = db.Exec("UPDATE migrations SET is_applied = false, state = 'pending', batch = 0, updated_at = CURRENT_TIMESTAMP WHERE key = $1", name)
 	}
 
 	if err != nil {
@@ -145,10 +446,9 @@ func GetMigrationStatus(db *sql.DB, name string) (bool, *khata.Khata) {
 	var isApplied bool
 
 	switch db.Driver().(type) {
-	case *mysql.MySQLDriver:
-	case *sqlite.Driver:
+	case *mysql.MySQLDriver, *sqlite.Driver:
 		err = db.QueryRow("SELECT is_applied FROM migrations WHERE key = ?", name).Scan(&isApplied)
-	case *pq.Driver:
+	case *pq.Driver, *stdlib.Driver, cockroachDriver:
 		err = db.QueryRow("SELECT is_applied FROM migrations WHERE key = $1", name).Scan(&isApplied)
 	}
 
@@ -165,10 +465,9 @@ func GetMigrationsFromDatabase(db *sql.DB, applied bool) ([]string, *khata.Khata
 	var rows *sql.Rows
 
 	switch db.Driver().(type) {
-	case *mysql.MySQLDriver:
-	case *sqlite.Driver:
+	case *mysql.MySQLDriver, *sqlite.Driver:
 		rows, err = db.Query("SELECT key FROM migrations WHERE is_applied = ?", applied)
-	case *pq.Driver:
+	case *pq.Driver, *stdlib.Driver, cockroachDriver:
 		rows, err = db.Query("SELECT key FROM migrations WHERE is_applied = $1", applied)
 	}
 
@@ -199,11 +498,10 @@ func GetAllMigrationsFromDatabase(db *sql.DB) ([]types.Migration, *khata.Khata)
 	var rows *sql.Rows
 
 	switch db.Driver().(type) {
-	case *mysql.MySQLDriver:
-	case *sqlite.Driver:
-		rows, err = db.Query("SELECT id, key, is_applied, created_at, updated_at FROM migrations")
-	case *pq.Driver:
-		rows, err = db.Query("SELECT id, key, is_applied, created_at, updated_at FROM migrations")
+	case *mysql.MySQLDriver, *sqlite.Driver:
+		rows, err = db.Query("SELECT id, key, is_applied, applied_filter, checksum, batch, state, created_at, updated_at FROM migrations")
+	case *pq.Driver, *stdlib.Driver, cockroachDriver:
+		rows, err = db.Query("SELECT id, key, is_applied, applied_filter, checksum, batch, state, created_at, updated_at FROM migrations")
 	}
 
 	if err != nil {
@@ -215,7 +513,7 @@ func GetAllMigrationsFromDatabase(db *sql.DB) ([]types.Migration, *khata.Khata)
 	for rows.Next() {
 		migration := types.Migration{}
 
-		err = rows.Scan(&migration.Id, &migration.Key, &migration.IsApplied, &migration.CreatedAt, &migration.UpdatedAt)
+		err = rows.Scan(&migration.Id, &migration.Key, &migration.IsApplied, &migration.Filter, &migration.Checksum, &migration.Batch, &migration.State, &migration.CreatedAt, &migration.UpdatedAt)
 
 		if err != nil {
 			return nil, errors.FatalError.Wrap(err).Explain("Could not scan migration row")
@@ -227,45 +525,285 @@ func GetAllMigrationsFromDatabase(db *sql.DB) ([]types.Migration, *khata.Khata)
 	return migrations, nil
 }
 
-func ExecuteMigration(db *sql.DB, sql string) *khata.Khata {
-	_, err := db.Exec(sql)
+func ExecuteMigration(ctx context.Context, db *sql.DB, sql string) *khata.Khata {
+	_, err := db.ExecContext(ctx, sql)
 
 	if err != nil {
-		return errors.FatalError.Wrap(err).Explain("Could not execute migration")
+		return wrapContextError(ctx, err, "Could not execute migration")
+	}
+
+	return nil
+}
+
+// RunMigration executes a migration according to its directives. Unless the
+// migration opts out with no-transaction, it runs inside a transaction, and
+// is retried with a backoff when it fails with a transient error that rolled
+// all of it back.
+func RunMigration(ctx context.Context, db *sql.DB, migrationObject types.MigrationObject, content string) *khata.Khata {
+	dialect := GetDialect(db)
+	retryable := isRetryableMigration(dialect, migrationObject.Directives.NoTransaction, content)
+
+	for attempt := 0; ; attempt++ {
+		kErr := runMigrationOnce(ctx, db, migrationObject, content)
+
+		if kErr == nil || !retryable || ctx.Err() != nil || !IsTransientError(kErr.Err) {
+			return kErr
+		}
+
+		retries := migrationObject.Directives.Retries
+
+		if dialect == "cockroach" && isSerializationFailure(kErr.Err) && retries < cockroachSerializationRetries {
+			retries = cockroachSerializationRetries
+		}
+
+		if attempt >= retries {
+			return kErr
+		}
+
+		delay := getRetryDelay(attempt)
+		PrintWarning(fmt.Sprintf("Migration %s failed with a transient error, retrying in %s: %s", migrationObject.Key, delay, kErr.Error()))
+
+		if !sleepContext(ctx, delay) {
+			return kErr
+		}
+	}
+}
+
+func runMigrationOnce(ctx context.Context, db *sql.DB, migrationObject types.MigrationObject, content string) *khata.Khata {
+	if migrationObject.Directives.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, migrationObject.Directives.Timeout)
+		defer cancel()
+	}
+
+	// PostgreSQL runs a multi-statement query as one implicit transaction, a
+	// migration without a transaction is sent statement by statement. Schema
+	// changes in a transaction are limited on CockroachDB, they run the same way.
+	if migrationObject.Directives.NoTransaction || (GetDialect(db) == "cockroach" && containsSchemaChange(content)) {
+		return runStatements(ctx, db, SplitSqlStatements(content))
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return wrapContextError(ctx, err, "Could not start migration transaction")
+	}
+
+	_, err = tx.ExecContext(ctx, content)
+
+	if err != nil {
+		tx.Rollback()
+		return wrapContextError(ctx, err, "Could not execute migration")
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return errors.FatalError.Wrap(err).Explain("Could not commit migration transaction")
+	}
+
+	return nil
+}
+
+// runStatements runs statements one by one outside of a transaction, on a
+// single connection so the session settings of one apply to the next.
+func runStatements(ctx context.Context, db *sql.DB, statements []string) *khata.Khata {
+	conn, err := db.Conn(ctx)
+
+	if err != nil {
+		return wrapContextError(ctx, err, "Could not get a connection for the migration")
+	}
+
+	defer conn.Close()
+
+	for _, statement := range statements {
+		_, err = conn.ExecContext(ctx, statement)
+
+		if err != nil {
+			return wrapContextError(ctx, err, "Could not execute migration")
+		}
+	}
+
+	return nil
+}
+
+// RunTrackedMigration records a migration as running before executing it, so
+// a failure or a crash leaves the database marked dirty. migration is the row
+// of the migration, the zero value when it has none yet.
+func RunTrackedMigration(
+	ctx context.Context,
+	db *sql.DB,
+	migrationObject types.MigrationObject,
+	content string,
+	migration types.Migration,
+) *khata.Khata {
+	// Stop between migrations once the run is cancelled
+	if err := ctx.Err(); err != nil {
+		return wrapContextError(ctx, err, "Migration run stopped before "+migrationObject.Key)
+	}
+
+	if migration.Key != migrationObject.Key {
+		kErr := CreateMigrationEntry(db, migrationObject.Key)
+
+		if kErr != nil {
+			return kErr.Explainf("Error creating migration entry: %s", migrationObject.Key)
+		}
+	}
+
+	kErr := SetMigrationState(db, migrationObject.Key, types.MigrationStateRunning)
+
+	if kErr != nil {
+		return kErr.Explainf("Error updating the state of migration: %s", migrationObject.Key)
+	}
+
+	kErr = RunMigration(ctx, db, migrationObject, content)
+
+	// The outcome is recorded even when the run was cancelled
+	if kErr != nil {
+		if stateErr := SetMigrationState(db, migrationObject.Key, GetFailedMigrationState(db, migrationObject, content, migration.State)); stateErr != nil {
+			PrintErrorMessage("Could not record the failure of migration " + migrationObject.Key)
+		}
+
+		return kErr.Explainf("Error running migration: %s", migrationObject.File)
 	}
 
 	return nil
 }
 
 func InitDb(cmd *cobra.Command) (*sql.DB, *khata.Khata) {
-	var supportedDrivers = []string{"mysql", "postgres", "sqlite"}
+	return InitDbWithTimeouts(cmd, "", "")
+}
 
-	connection := GetStringArg(cmd, "connection", "MONARCH_CONNECTION_STRING", "")
+// InitDbWithTimeouts connects like InitDb and sets the lock and statement
+// timeouts on every connection of the pool, the empty ones are left unset.
+func InitDbWithTimeouts(cmd *cobra.Command, lockTimeout string, statementTimeout string) (*sql.DB, *khata.Khata) {
+	driver, connection, envConfig, kErr := GetDatabaseConnection(cmd)
+
+	if kErr != nil {
+		return nil, kErr
+	}
+
+	sessionStatements := append(
+		append([]string{}, envConfig.SessionInit...),
+		GetSessionTimeoutStatements(GetDriverDialect(driver), lockTimeout, statementTimeout)...,
+	)
+
+	// Try to connect to the database, the session statements of the
+	// environment run on each connection before any migration
+	db, err := WaitForDatabase(
+		driver,
+		connection,
+		sessionStatements,
+		GetDurationArg(cmd, "wait-for-db", "MONARCH_WAIT_FOR_DB", 0),
+	)
+
+	if err != nil {
+		return nil, errors.FatalError.Wrap(err).Explain("Could not connect to database")
+	}
+
+	return db, err
+}
+
+// GetDatabaseConnection resolves the driver and the connection string of the
+// database from the flags, the config and the environment, with the password
+// and the TLS settings applied, without connecting to it.
+func GetDatabaseConnection(cmd *cobra.Command) (string, string, types.EnvironmentConfig, *khata.Khata) {
+	var supportedDrivers = []string{"mysql", "postgres", "pgx", "cockroach", "sqlite"}
+
+	config, kErr := LoadConfig()
+
+	if kErr != nil {
+		return "", "", types.EnvironmentConfig{}, kErr.Explain("Could not load config")
+	}
+
+	// The environment section of the config takes precedence over the
+	// variables but not over the flags
+	envConfig := GetEnvironmentConfig(config, GetEnvironmentArg(cmd))
+
+	connection, kErr := GetConnectionString(cmd, envConfig)
+
+	if kErr != nil {
+		return "", "", envConfig, kErr.Explain("Could not get connection string")
+	}
 
 	if connection == "" {
 
-		return nil, errors.FatalError.New("connection string is required")
+		return "", "", envConfig, errors.FatalError.New("connection string is required")
 	}
 
-	driver := GetStringArg(cmd, "driver", "MONARCH_DRIVER", "")
+	driver := GetStringArg(cmd, "driver", "", envConfig.Driver)
 
 	if driver == "" {
-		return nil, errors.FatalError.New("driver is required")
+		driver = GetEnv("MONARCH_DRIVER")
+	}
+
+	// URL connection strings carry their driver, which --driver overrides
+	driver, connection, kErr = ResolveConnection(driver, connection)
+
+	if kErr != nil {
+		return "", "", envConfig, kErr.Explain("Could not parse connection string")
+	}
+
+	if driver == "" {
+		return "", "", envConfig, errors.FatalError.New("driver is required, set it or use a URL connection string such as postgres://...")
 	}
 
 	// Check if the driver is supported
 	supported := IsDriverSupported(driver, supportedDrivers)
 
 	if !supported {
-		return nil, errors.FatalError.New("driver not supported")
+		return "", "", envConfig, errors.FatalError.New("driver not supported")
 	}
 
-	// Try to connect to the database
-	db, err := ConnectToDatabase(driver, connection)
+	password, kErr := GetConnectionPassword(envConfig)
 
-	if err != nil {
-		return nil, errors.FatalError.Wrap(err).Explain("Could not connect to database")
+	if kErr != nil {
+		return "", "", envConfig, kErr.Explain("Could not get connection password")
 	}
 
-	return db, err
+	if password != "" {
+		connection, kErr = SetConnectionPassword(driver, connection, password)
+
+		if kErr != nil {
+			return "", "", envConfig, kErr
+		}
+	}
+
+	if envConfig.TLS != nil {
+		connection, kErr = SetConnectionTLS(driver, connection, *envConfig.TLS)
+
+		if kErr != nil {
+			return "", "", envConfig, kErr.Explain("Could not apply tls settings")
+		}
+	}
+
+	RegisterConnectionSecrets(driver, connection)
+
+	return driver, connection, envConfig, nil
+}
+
+// GetDialect returns the name of the SQL dialect spoken by the database.
+func GetDialect(db *sql.DB) string {
+	switch db.Driver().(type) {
+	case *mysql.MySQLDriver:
+		return "mysql"
+	case *pq.Driver, *stdlib.Driver:
+		return "postgres"
+	case cockroachDriver:
+		return "cockroach"
+	case *sqlite.Driver:
+		return "sqlite"
+	}
+
+	return ""
+}
+
+// GetDriverDialect returns the SQL dialect spoken through a driver, pgx and
+// lib/pq both speaking PostgreSQL.
+func GetDriverDialect(driver string) string {
+	if driver == "pgx" {
+		return "postgres"
+	}
+
+	return driver
 }
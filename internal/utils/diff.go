@@ -0,0 +1,215 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cmseguin/monarch/internal/types"
+)
+
+// DiffSchemas compares the expected schema with the actual one. Missing
+// objects exist only in the expected schema, extra objects only in the actual
+// one. Column types are only compared when both schemas share a dialect.
+func DiffSchemas(expected types.Schema, actual types.Schema) types.SchemaDiff {
+	diff := types.SchemaDiff{
+		MissingTables: []string{},
+		ExtraTables:   []string{},
+		Tables:        []types.SchemaTableDiff{},
+		MissingViews:  []string{},
+		ExtraViews:    []string{},
+	}
+
+	actualTables := map[string]types.SchemaTable{}
+	for _, table := range actual.Tables {
+		actualTables[table.Name] = table
+	}
+
+	expectedTables := map[string]types.SchemaTable{}
+	for _, table := range expected.Tables {
+		expectedTables[table.Name] = table
+	}
+
+	for _, expectedTable := range expected.Tables {
+		actualTable, ok := actualTables[expectedTable.Name]
+
+		if !ok {
+			diff.MissingTables = append(diff.MissingTables, expectedTable.Name)
+			continue
+		}
+
+		tableDiff := diffTables(expectedTable, actualTable, expected.Dialect == actual.Dialect)
+
+		if !isTableDiffEmpty(tableDiff) {
+			diff.Tables = append(diff.Tables, tableDiff)
+		}
+	}
+
+	for _, actualTable := range actual.Tables {
+		if _, ok := expectedTables[actualTable.Name]; !ok {
+			diff.ExtraTables = append(diff.ExtraTables, actualTable.Name)
+		}
+	}
+
+	expectedViews := map[string]bool{}
+	for _, view := range expected.Views {
+		expectedViews[view.Name] = true
+	}
+
+	actualViews := map[string]bool{}
+	for _, view := range actual.Views {
+		actualViews[view.Name] = true
+	}
+
+	for _, view := range expected.Views {
+		if !actualViews[view.Name] {
+			diff.MissingViews = append(diff.MissingViews, view.Name)
+		}
+	}
+
+	for _, view := range actual.Views {
+		if !expectedViews[view.Name] {
+			diff.ExtraViews = append(diff.ExtraViews, view.Name)
+		}
+	}
+
+	return diff
+}
+
+func diffTables(expected types.SchemaTable, actual types.SchemaTable, compareTypes bool) types.SchemaTableDiff {
+	tableDiff := types.SchemaTableDiff{
+		Name:               expected.Name,
+		MissingColumns:     []string{},
+		ExtraColumns:       []string{},
+		ChangedColumns:     []string{},
+		MissingIndexes:     []string{},
+		ExtraIndexes:       []string{},
+		MissingConstraints: []string{},
+		ExtraConstraints:   []string{},
+	}
+
+	actualColumns := map[string]types.SchemaColumn{}
+	for _, column := range actual.Columns {
+		actualColumns[column.Name] = column
+	}
+
+	expectedColumns := map[string]bool{}
+
+	for _, expectedColumn := range expected.Columns {
+		expectedColumns[expectedColumn.Name] = true
+		actualColumn, ok := actualColumns[expectedColumn.Name]
+
+		if !ok {
+			tableDiff.MissingColumns = append(tableDiff.MissingColumns, expectedColumn.Name)
+			continue
+		}
+
+		if expectedColumn.Nullable != actualColumn.Nullable ||
+			(compareTypes && (!strings.EqualFold(expectedColumn.Type, actualColumn.Type) || expectedColumn.Default != actualColumn.Default)) {
+			tableDiff.ChangedColumns = append(
+				tableDiff.ChangedColumns,
+				fmt.Sprintf("%s: expected %s, found %s", expectedColumn.Name, RenderColumnSQL(expectedColumn), RenderColumnSQL(actualColumn)),
+			)
+		}
+	}
+
+	for _, actualColumn := range actual.Columns {
+		if !expectedColumns[actualColumn.Name] {
+			tableDiff.ExtraColumns = append(tableDiff.ExtraColumns, actualColumn.Name)
+		}
+	}
+
+	// Indexes and constraints are compared by what they cover rather than by
+	// name since generated names differ between databases
+	indexKey := func(index types.SchemaIndex) string {
+		return fmt.Sprintf("%t:%s", index.Unique, strings.ToLower(strings.Join(index.Columns, ",")))
+	}
+
+	tableDiff.MissingIndexes, tableDiff.ExtraIndexes = diffByKey(
+		expected.Indexes, actual.Indexes, indexKey,
+		func(index types.SchemaIndex) string { return RenderCreateIndexSQL(expected.Name, index) },
+	)
+
+	constraintKey := func(constraint types.SchemaConstraint) string {
+		return strings.Join(strings.Fields(strings.ToLower(constraint.Definition)), " ")
+	}
+
+	tableDiff.MissingConstraints, tableDiff.ExtraConstraints = diffByKey(
+		expected.Constraints, actual.Constraints, constraintKey, RenderConstraintSQL,
+	)
+
+	return tableDiff
+}
+
+func diffByKey[T any](expected []T, actual []T, key func(T) string, describe func(T) string) ([]string, []string) {
+	missing := []string{}
+	extra := []string{}
+
+	actualKeys := map[string]bool{}
+	for _, item := range actual {
+		actualKeys[key(item)] = true
+	}
+
+	expectedKeys := map[string]bool{}
+	for _, item := range expected {
+		expectedKeys[key(item)] = true
+
+		if !actualKeys[key(item)] {
+			missing = append(missing, describe(item))
+		}
+	}
+
+	for _, item := range actual {
+		if !expectedKeys[key(item)] {
+			extra = append(extra, describe(item))
+		}
+	}
+
+	return missing, extra
+}
+
+func isTableDiffEmpty(tableDiff types.SchemaTableDiff) bool {
+	return len(tableDiff.MissingColumns) == 0 &&
+		len(tableDiff.ExtraColumns) == 0 &&
+		len(tableDiff.ChangedColumns) == 0 &&
+		len(tableDiff.MissingIndexes) == 0 &&
+		len(tableDiff.ExtraIndexes) == 0 &&
+		len(tableDiff.MissingConstraints) == 0 &&
+		len(tableDiff.ExtraConstraints) == 0
+}
+
+func IsSchemaDiffEmpty(diff types.SchemaDiff) bool {
+	return len(diff.MissingTables) == 0 &&
+		len(diff.ExtraTables) == 0 &&
+		len(diff.Tables) == 0 &&
+		len(diff.MissingViews) == 0 &&
+		len(diff.ExtraViews) == 0
+}
+
+// PrintSchemaDiff prints the differences in a human readable form.
+func PrintSchemaDiff(diff types.SchemaDiff) {
+	printSection := func(title string, items []string) {
+		if len(items) == 0 {
+			return
+		}
+
+		PrintInfo(title)
+		PrintUnorderedList(items)
+	}
+
+	printSection("Missing tables:", diff.MissingTables)
+	printSection("Extra tables:", diff.ExtraTables)
+
+	for _, tableDiff := range diff.Tables {
+		PrintWarning("Table " + tableDiff.Name + ":")
+		printSection("  Missing columns:", tableDiff.MissingColumns)
+		printSection("  Extra columns:", tableDiff.ExtraColumns)
+		printSection("  Changed columns:", tableDiff.ChangedColumns)
+		printSection("  Missing indexes:", tableDiff.MissingIndexes)
+		printSection("  Extra indexes:", tableDiff.ExtraIndexes)
+		printSection("  Missing constraints:", tableDiff.MissingConstraints)
+		printSection("  Extra constraints:", tableDiff.ExtraConstraints)
+	}
+
+	printSection("Missing views:", diff.MissingViews)
+	printSection("Extra views:", diff.ExtraViews)
+}
@@ -0,0 +1,278 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/types"
+)
+
+const directivePrefix = "monarch:"
+
+// ParseMigrationDirectives reads the `-- monarch:` comments at the top of a
+// migration. Parsing stops at the first line that is not a comment.
+func ParseMigrationDirectives(content string) (types.MigrationDirectives, *khata.Khata) {
+	directives := types.MigrationDirectives{}
+
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+
+		comment := strings.TrimSpace(strings.TrimPrefix(line, "--"))
+
+		if !strings.HasPrefix(comment, directivePrefix) {
+			continue
+		}
+
+		name, value, _ := strings.Cut(strings.TrimPrefix(comment, directivePrefix), "=")
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+
+		switch name {
+		case "no-transaction":
+			directives.NoTransaction = true
+		case "irreversible":
+			directives.Irreversible = true
+		case "rerunnable":
+			directives.Rerunnable = true
+		case "template":
+			directives.Template = true
+		case "timeout":
+			timeout, err := time.ParseDuration(value)
+
+			if err != nil || timeout <= 0 {
+				return directives, errors.FatalError.New("invalid timeout directive: " + value)
+			}
+
+			directives.Timeout = timeout
+		case "retries":
+			retries, err := strconv.Atoi(value)
+
+			if err != nil || retries < 0 {
+				return directives, errors.FatalError.New("invalid retries directive: " + value)
+			}
+
+			directives.Retries = retries
+		case "env":
+			directives.Envs = append(directives.Envs, splitDirectiveList(value)...)
+		case "tags":
+			directives.Tags = append(directives.Tags, splitDirectiveList(value)...)
+		case "requires":
+			directives.Requires = append(directives.Requires, splitDirectiveList(value)...)
+		case "squashes":
+			directives.Squashes = append(directives.Squashes, splitDirectiveList(value)...)
+		default:
+			return directives, errors.FatalError.New("unknown directive: " + name)
+		}
+	}
+
+	return directives, nil
+}
+
+func splitDirectiveList(value string) []string {
+	list := []string{}
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// IsMigrationEnabledForEnv checks the env directive of a migration against the
+// environment monarch runs in. Migrations without the directive always run.
+func IsMigrationEnabledForEnv(directives types.MigrationDirectives, env string) bool {
+	if len(directives.Envs) == 0 {
+		return true
+	}
+
+	for _, allowedEnv := range directives.Envs {
+		if allowedEnv == env {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsMigrationSelected checks the env and tags of a migration against the
+// filter. When tags are given, only migrations carrying one of them match.
+func IsMigrationSelected(directives types.MigrationDirectives, filter types.MigrationFilter) bool {
+	if !IsMigrationEnabledForEnv(directives, filter.Env) {
+		return false
+	}
+
+	hasTag := func(tags []string) bool {
+		for _, tag := range directives.Tags {
+			if FindIndexInString(tags, func(value string, _ int) bool { return value == tag }) != -1 {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(filter.Tags) > 0 && !hasTag(filter.Tags) {
+		return false
+	}
+
+	if len(filter.ExcludeTags) > 0 && hasTag(filter.ExcludeTags) {
+		return false
+	}
+
+	return true
+}
+
+// SplitMigrationsByFilter separates the migrations selected by the filter
+// from the ones it skips, preserving their order.
+func SplitMigrationsByFilter(
+	migrationObjects []types.MigrationObject,
+	filter types.MigrationFilter,
+) ([]types.MigrationObject, []types.MigrationObject) {
+	selected := []types.MigrationObject{}
+	skipped := []types.MigrationObject{}
+
+	for _, migrationObject := range migrationObjects {
+		if IsMigrationSelected(migrationObject.Directives, filter) {
+			selected = append(selected, migrationObject)
+		} else {
+			skipped = append(skipped, migrationObject)
+		}
+	}
+
+	return selected, skipped
+}
+
+// FormatMigrationFilter describes the filter the way it is recorded in the
+// migrations table. An empty filter is recorded as an empty string.
+func FormatMigrationFilter(filter types.MigrationFilter) string {
+	parts := []string{}
+
+	if filter.Env != "" {
+		parts = append(parts, "env="+filter.Env)
+	}
+
+	if len(filter.Tags) > 0 {
+		parts = append(parts, "tags="+strings.Join(filter.Tags, ","))
+	}
+
+	if len(filter.ExcludeTags) > 0 {
+		parts = append(parts, "exclude-tags="+strings.Join(filter.ExcludeTags, ","))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// CheckMigrationRequirements makes sure every migration required by the
+// migration is either applied or runs before it.
+func CheckMigrationRequirements(
+	migrationObject types.MigrationObject,
+	appliedMigrationKeys []string,
+	previousMigrationKeys []string,
+) *khata.Khata {
+	for _, required := range migrationObject.Directives.Requires {
+		if FindIndexInString(appliedMigrationKeys, func(key string, _ int) bool {
+			return key == required || GetMigrationName(key) == required
+		}) != -1 {
+			continue
+		}
+
+		if FindIndexInString(previousMigrationKeys, func(key string, _ int) bool {
+			return key == required || GetMigrationName(key) == required
+		}) != -1 {
+			continue
+		}
+
+		return errors.FatalError.New("migration " + migrationObject.Key + " requires " + required + " to be applied first")
+	}
+
+	return nil
+}
+
+// IsBaselineSatisfied tells whether a baseline created by squash stands for
+// migrations the database already applied, in which case it must be recorded
+// as applied without running. A database that applied only some of the
+// squashed migrations cannot be brought to the baseline and is an error.
+func IsBaselineSatisfied(migrationObject types.MigrationObject, appliedMigrationKeys []string) (bool, *khata.Khata) {
+	missingMigrationKeys := []string{}
+
+	for _, squashed := range migrationObject.Directives.Squashes {
+		if FindIndexInString(appliedMigrationKeys, func(key string, _ int) bool { return key == squashed }) == -1 {
+			missingMigrationKeys = append(missingMigrationKeys, squashed)
+		}
+	}
+
+	if len(missingMigrationKeys) == len(migrationObject.Directives.Squashes) {
+		return false, nil
+	}
+
+	if len(missingMigrationKeys) > 0 {
+		return false, errors.FatalError.New(
+			"partially applied squash range: baseline "+migrationObject.Key+" squashes migrations the database did not apply",
+			SPrintUnorderedList(missingMigrationKeys),
+		)
+	}
+
+	return true, nil
+}
+
+// AddSquashedMigrationKeys returns the keys with the keys squashed by the
+// baselines among them, so a migration requiring a squashed migration is
+// satisfied by its baseline.
+func AddSquashedMigrationKeys(migrationObjects []types.MigrationObject, keys []string) []string {
+	result := append([]string{}, keys...)
+
+	for _, migrationObject := range migrationObjects {
+		if FindIndexInString(keys, func(key string, _ int) bool { return key == migrationObject.Key }) != -1 {
+			result = append(result, migrationObject.Directives.Squashes...)
+		}
+	}
+
+	return result
+}
+
+// CheckRollbackDependents makes sure no migration that stays applied requires
+// one of the migrations being rolled back.
+func CheckRollbackDependents(
+	upMigrationObjects []types.MigrationObject,
+	appliedMigrationKeys []string,
+	rollbackMigrationKeys []string,
+) *khata.Khata {
+	for _, upMigrationObject := range upMigrationObjects {
+		isApplied := FindIndexInString(appliedMigrationKeys, func(key string, _ int) bool {
+			return key == upMigrationObject.Key
+		}) != -1
+
+		isRolledBack := FindIndexInString(rollbackMigrationKeys, func(key string, _ int) bool {
+			return key == upMigrationObject.Key
+		}) != -1
+
+		if !isApplied || isRolledBack {
+			continue
+		}
+
+		for _, required := range upMigrationObject.Directives.Requires {
+			if FindIndexInString(rollbackMigrationKeys, func(key string, _ int) bool {
+				return key == required || GetMigrationName(key) == required
+			}) != -1 {
+				return errors.FatalError.New("migration " + upMigrationObject.Key + " requires " + required + " and is still applied")
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cmseguin/monarch/internal/types"
+)
+
+func TestParseMigrationDirectives(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    types.MigrationDirectives
+	}{
+		{
+			name:    "no directives",
+			content: "CREATE TABLE a (id INT);",
+			want:    types.MigrationDirectives{},
+		},
+		{
+			name:    "flags",
+			content: "-- monarch:no-transaction\n-- monarch:irreversible\n--monarch:rerunnable\nCREATE INDEX i ON a (id);",
+			want:    types.MigrationDirectives{NoTransaction: true, Irreversible: true, Rerunnable: true},
+		},
+		{
+			name:    "values",
+			content: "-- monarch:timeout=30s\n-- monarch:retries = 3\n\n-- monarch:env=staging, prod\n-- monarch:tags=seed\n-- monarch:requires=users,posts\nSELECT 1;",
+			want: types.MigrationDirectives{
+				Timeout:  30 * time.Second,
+				Retries:  3,
+				Envs:     []string{"staging", "prod"},
+				Tags:     []string{"seed"},
+				Requires: []string{"users", "posts"},
+			},
+		},
+		{
+			name:    "repeated list directives",
+			content: "-- monarch:squashes=0001-a\n-- monarch:squashes=0002-b,\nSELECT 1;",
+			want:    types.MigrationDirectives{Squashes: []string{"0001-a", "0002-b"}},
+		},
+		{
+			name:    "other comments",
+			content: "-- Adds the users table\n-- monarch:no-transaction\nSELECT 1;",
+			want:    types.MigrationDirectives{NoTransaction: true},
+		},
+		{
+			name:    "directives after the header",
+			content: "SELECT 1;\n-- monarch:no-transaction\n",
+			want:    types.MigrationDirectives{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, kErr := ParseMigrationDirectives(tt.content)
+
+			if kErr != nil {
+				t.Fatalf("ParseMigrationDirectives(%q): %v", tt.content, kErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMigrationDirectives(%q) = %+v, want %+v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMigrationDirectivesErrors(t *testing.T) {
+	for _, content := range []string{
+		"-- monarch:timeout=soon",
+		"-- monarch:timeout=-1s",
+		"-- monarch:retries=-1",
+		"-- monarch:retries=many",
+		"-- monarch:transactional",
+	} {
+		if _, kErr := ParseMigrationDirectives(content); kErr == nil {
+			t.Errorf("ParseMigrationDirectives(%q) succeeded, want an error", content)
+		}
+	}
+}
+
+func TestCheckMigrationRequirements(t *testing.T) {
+	tests := []struct {
+		name     string
+		requires string
+		applied  []string
+		wantErr  bool
+	}{
+		{name: "full key", requires: "0001-users", applied: []string{"0001-users"}},
+		{name: "name", requires: "users", applied: []string{"0001-users"}},
+		{name: "semantic name", requires: "users", applied: []string{"V1_2__users"}},
+		{name: "name suffix", requires: "users", applied: []string{"0001-old-users"}, wantErr: true},
+		{name: "missing", requires: "users", applied: []string{}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrationObject := types.MigrationObject{
+				Key:        "0002-posts",
+				Directives: types.MigrationDirectives{Requires: []string{tt.requires}},
+			}
+
+			kErr := CheckMigrationRequirements(migrationObject, tt.applied, []string{})
+
+			if (kErr != nil) != tt.wantErr {
+				t.Errorf("CheckMigrationRequirements(%q, %q) = %v, want error %v", tt.requires, tt.applied, kErr, tt.wantErr)
+			}
+		})
+	}
+}
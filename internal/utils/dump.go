@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"database/sql"
+	"os"
+	"path"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/spf13/cobra"
+)
+
+const schemaDumpHeader = "-- Schema dumped by monarch, do not edit by hand\n\n"
+
+// GetSchemaDumpPath returns where the schema dump is written: the --output
+// flag, the schema_file of the config, relative to the config file, or
+// schema.sql next to the migrations.
+func GetSchemaDumpPath(cmd *cobra.Command) (string, *khata.Khata) {
+	if output := GetStringArg(cmd, "output", "MONARCH_SCHEMA_FILE", ""); output != "" {
+		return output, nil
+	}
+
+	config, kErr := LoadConfig()
+
+	if kErr != nil {
+		return "", kErr
+	}
+
+	if config.SchemaFile != "" {
+		return ResolveConfigRelativePath(config.SchemaFile), nil
+	}
+
+	installDir, kErr := FindInstallationPath()
+
+	if kErr != nil {
+		return "", kErr.Explain("Could not find installation path")
+	}
+
+	return path.Join(installDir, "schema.sql"), nil
+}
+
+// RenderSchemaDump introspects the database and renders the content of the
+// schema dump.
+func RenderSchemaDump(db *sql.DB) (string, *khata.Khata) {
+	schema, kErr := IntrospectSchema(db)
+
+	if kErr != nil {
+		return "", kErr
+	}
+
+	return schemaDumpHeader + RenderSchemaSQL(schema), nil
+}
+
+// DumpSchema writes the schema dump of the database to a file.
+func DumpSchema(db *sql.DB, dumpPath string) *khata.Khata {
+	content, kErr := RenderSchemaDump(db)
+
+	if kErr != nil {
+		return kErr
+	}
+
+	err := os.WriteFile(dumpPath, []byte(content), 0644)
+
+	if err != nil {
+		return errors.FatalError.Wrap(err).Explain("Could not write schema dump")
+	}
+
+	return nil
+}
+
+// IsSchemaDumpStale tells whether the dump on disk differs from the schema of
+// the database. A missing dump is stale.
+func IsSchemaDumpStale(db *sql.DB, dumpPath string) (bool, *khata.Khata) {
+	content, kErr := RenderSchemaDump(db)
+
+	if kErr != nil {
+		return false, kErr
+	}
+
+	existing, err := os.ReadFile(dumpPath)
+
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+
+	if err != nil {
+		return false, errors.FatalError.Wrap(err).Explain("Could not read schema dump")
+	}
+
+	return string(existing) != content, nil
+}
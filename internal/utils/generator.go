@@ -0,0 +1,312 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/types"
+)
+
+type createTemplate struct {
+	up       string
+	down     string
+	required []string
+}
+
+var builtinCreateTemplates = map[string]createTemplate{
+	"create-table": {
+		up: `CREATE TABLE {{ .Table }} (
+{{- if eq .Dialect "postgres" }}
+	id BIGSERIAL PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+{{- else if eq .Dialect "cockroach" }}
+	id INT8 PRIMARY KEY DEFAULT unique_rowid(),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+{{- else if eq .Dialect "mysql" }}
+	id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+{{- else }}
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+{{- end }}
+);
+`,
+		down: `DROP TABLE {{ .Table }};
+`,
+		required: []string{"table"},
+	},
+	"add-column": {
+		up: `ALTER TABLE {{ .Table }} ADD COLUMN {{ .Column }} {{ .Type }};
+`,
+		down: `ALTER TABLE {{ .Table }} DROP COLUMN {{ .Column }};
+`,
+		required: []string{"table", "column", "type"},
+	},
+	"add-index": {
+		up: `{{- if eq .Dialect "postgres" -}}
+-- monarch:no-transaction
+CREATE INDEX CONCURRENTLY idx_{{ .Table }}_{{ join .Columns "_" }} ON {{ .Table }} ({{ join .Columns ", " }});
+{{- else -}}
+CREATE INDEX idx_{{ .Table }}_{{ join .Columns "_" }} ON {{ .Table }} ({{ join .Columns ", " }});
+{{- end }}
+`,
+		down: `{{- if eq .Dialect "postgres" -}}
+-- monarch:no-transaction
+DROP INDEX CONCURRENTLY idx_{{ .Table }}_{{ join .Columns "_" }};
+{{- else if eq .Dialect "mysql" -}}
+DROP INDEX idx_{{ .Table }}_{{ join .Columns "_" }} ON {{ .Table }};
+{{- else -}}
+DROP INDEX idx_{{ .Table }}_{{ join .Columns "_" }};
+{{- end }}
+`,
+		required: []string{"table", "columns"},
+	},
+}
+
+// RenderCreateTemplate renders the up and down bodies of a new migration.
+// Templates in the project's migrations/.templates directory, named
+// <template>.up.sql and <template>.down.sql, take precedence over the
+// built-in ones.
+func RenderCreateTemplate(
+	migrationDir string,
+	templateName string,
+	data types.CreateTemplateData,
+) (string, string, *khata.Khata) {
+	tmpl, kErr := getCreateTemplate(migrationDir, templateName)
+
+	if kErr != nil {
+		return "", "", kErr
+	}
+
+	for _, required := range tmpl.required {
+		if (required == "table" && data.Table == "") ||
+			(required == "column" && data.Column == "") ||
+			(required == "type" && data.Type == "") ||
+			(required == "columns" && len(data.Columns) == 0) {
+			return "", "", errors.FatalError.New("template " + templateName + " requires --" + required)
+		}
+	}
+
+	up, kErr := renderCreateTemplateBody(templateName+".up.sql", tmpl.up, data)
+
+	if kErr != nil {
+		return "", "", kErr
+	}
+
+	down, kErr := renderCreateTemplateBody(templateName+".down.sql", tmpl.down, data)
+
+	if kErr != nil {
+		return "", "", kErr
+	}
+
+	return up, down, nil
+}
+
+func getCreateTemplate(migrationDir string, templateName string) (createTemplate, *khata.Khata) {
+	templateDir := path.Join(migrationDir, ".templates")
+
+	up, upErr := os.ReadFile(path.Join(templateDir, templateName+".up.sql"))
+	down, downErr := os.ReadFile(path.Join(templateDir, templateName+".down.sql"))
+
+	if upErr == nil || downErr == nil {
+		return createTemplate{up: string(up), down: string(down)}, nil
+	}
+
+	tmpl, ok := builtinCreateTemplates[templateName]
+
+	if !ok {
+		return tmpl, errors.FatalError.New("unknown template: " + templateName)
+	}
+
+	return tmpl, nil
+}
+
+func renderCreateTemplateBody(name string, body string, data types.CreateTemplateData) (string, *khata.Khata) {
+	tmpl, err := template.New(name).
+		Option("missingkey=error").
+		Funcs(template.FuncMap{"join": strings.Join}).
+		Parse(body)
+
+	if err != nil {
+		return "", errors.FatalError.Wrap(err).Explainf("Could not parse template %s", name)
+	}
+
+	var rendered strings.Builder
+
+	err = tmpl.Execute(&rendered, data)
+
+	if err != nil {
+		return "", errors.FatalError.Wrap(err).Explainf("Could not render template %s", name)
+	}
+
+	return rendered.String(), nil
+}
+
+// GenerateMigrationFromSchemas writes the statements that turn the current
+// schema into the desired one, along with best-effort statements undoing
+// them. Tables, columns and indexes are handled, other changes are left as
+// comments to review.
+func GenerateMigrationFromSchemas(current types.Schema, desired types.Schema) (string, string) {
+	up := []string{}
+	down := []string{}
+
+	currentTables := map[string]types.SchemaTable{}
+	for _, table := range current.Tables {
+		currentTables[table.Name] = table
+	}
+
+	desiredTables := map[string]types.SchemaTable{}
+	for _, table := range desired.Tables {
+		desiredTables[table.Name] = table
+	}
+
+	for _, desiredTable := range orderTablesByDependencies(desired.Tables) {
+		currentTable, ok := currentTables[desiredTable.Name]
+
+		if !ok {
+			up = append(up, strings.TrimSuffix(RenderCreateTableSQL(desiredTable), "\n"))
+			down = append(down, "DROP TABLE "+desiredTable.Name+";")
+
+			for _, index := range desiredTable.Indexes {
+				up = append(up, RenderCreateIndexSQL(desiredTable.Name, index))
+			}
+
+			continue
+		}
+
+		tableUp, tableDown := generateTableChanges(desired.Dialect, currentTable, desiredTable)
+		up = append(up, tableUp...)
+		down = append(down, tableDown...)
+	}
+
+	for _, currentTable := range current.Tables {
+		if _, ok := desiredTables[currentTable.Name]; ok {
+			continue
+		}
+
+		up = append(up, "DROP TABLE "+currentTable.Name+";")
+
+		// The down statements are reversed, the indexes go before their table
+		for _, index := range currentTable.Indexes {
+			down = append(down, RenderCreateIndexSQL(currentTable.Name, index))
+		}
+
+		down = append(down, strings.TrimSuffix(RenderCreateTableSQL(currentTable), "\n"))
+	}
+
+	diff := DiffSchemas(desired, current)
+
+	for _, view := range diff.MissingViews {
+		up = append(up, "-- TODO: create view "+view)
+	}
+
+	for _, view := range diff.ExtraViews {
+		up = append(up, "-- TODO: drop view "+view)
+	}
+
+	if len(up) == 0 {
+		return "", ""
+	}
+
+	// Undo the changes in the reverse order
+	for i, j := 0, len(down)-1; i < j; i, j = i+1, j-1 {
+		down[i], down[j] = down[j], down[i]
+	}
+
+	return strings.Join(up, "\n\n") + "\n", strings.Join(down, "\n\n") + "\n"
+}
+
+func generateTableChanges(dialect string, current types.SchemaTable, desired types.SchemaTable) ([]string, []string) {
+	up := []string{}
+	down := []string{}
+
+	currentColumns := map[string]types.SchemaColumn{}
+	for _, column := range current.Columns {
+		currentColumns[column.Name] = column
+	}
+
+	desiredColumns := map[string]types.SchemaColumn{}
+	for _, column := range desired.Columns {
+		desiredColumns[column.Name] = column
+	}
+
+	for _, column := range desired.Columns {
+		currentColumn, ok := currentColumns[column.Name]
+
+		if !ok {
+			up = append(up, "ALTER TABLE "+desired.Name+" ADD COLUMN "+RenderColumnSQL(column)+";")
+			down = append(down, "ALTER TABLE "+desired.Name+" DROP COLUMN "+column.Name+";")
+			continue
+		}
+
+		if RenderColumnSQL(column) != RenderColumnSQL(currentColumn) {
+			up = append(up, "-- TODO: change column "+desired.Name+"."+RenderColumnSQL(currentColumn)+" to "+RenderColumnSQL(column))
+		}
+	}
+
+	for _, column := range current.Columns {
+		if _, ok := desiredColumns[column.Name]; ok {
+			continue
+		}
+
+		up = append(up, "ALTER TABLE "+desired.Name+" DROP COLUMN "+column.Name+";")
+		down = append(down, "ALTER TABLE "+desired.Name+" ADD COLUMN "+RenderColumnSQL(column)+";")
+	}
+
+	indexKey := func(index types.SchemaIndex) string {
+		return fmt.Sprintf("%s:%t:%s", index.Name, index.Unique, strings.Join(index.Columns, ","))
+	}
+
+	currentIndexes := map[string]bool{}
+	for _, index := range current.Indexes {
+		currentIndexes[indexKey(index)] = true
+	}
+
+	desiredIndexes := map[string]bool{}
+	for _, index := range desired.Indexes {
+		desiredIndexes[indexKey(index)] = true
+	}
+
+	for _, index := range current.Indexes {
+		if !desiredIndexes[indexKey(index)] {
+			up = append(up, renderDropIndexSQL(dialect, desired.Name, index.Name))
+			down = append(down, RenderCreateIndexSQL(desired.Name, index))
+		}
+	}
+
+	for _, index := range desired.Indexes {
+		if !currentIndexes[indexKey(index)] {
+			up = append(up, RenderCreateIndexSQL(desired.Name, index))
+			down = append(down, renderDropIndexSQL(dialect, desired.Name, index.Name))
+		}
+	}
+
+	tableDiff := diffTables(desired, current, true)
+
+	for _, constraint := range tableDiff.MissingConstraints {
+		up = append(up, "-- TODO: add constraint to "+desired.Name+": "+constraint)
+	}
+
+	for _, constraint := range tableDiff.ExtraConstraints {
+		up = append(up, "-- TODO: drop constraint from "+desired.Name+": "+constraint)
+	}
+
+	return up, down
+}
+
+func renderDropIndexSQL(dialect string, tableName string, indexName string) string {
+	if dialect == "mysql" {
+		return "DROP INDEX " + indexName + " ON " + tableName + ";"
+	}
+
+	return "DROP INDEX " + indexName + ";"
+}
@@ -7,43 +7,43 @@ import (
 )
 
 func PrintStmt(stmt string) {
-	fmt.Println(stmt)
+	fmt.Println(RedactSecrets(stmt))
 }
 
 func SPrintStmt(stmt string) string {
-	return fmt.Sprintln(stmt)
+	return fmt.Sprintln(RedactSecrets(stmt))
 }
 
 func PrintSuccess(message string) {
-	fmt.Println(chalk.Green.Color(message))
+	fmt.Println(chalk.Green.Color(RedactSecrets(message)))
 }
 
 func SPrintSuccess(message string) string {
-	return fmt.Sprintln(chalk.Green.Color(message))
+	return fmt.Sprintln(chalk.Green.Color(RedactSecrets(message)))
 }
 
 func PrintInfo(message string) {
-	fmt.Println(chalk.Cyan.Color(message))
+	fmt.Println(chalk.Cyan.Color(RedactSecrets(message)))
 }
 
 func SPrintInfo(message string) string {
-	return fmt.Sprintln(chalk.Cyan.Color(message))
+	return fmt.Sprintln(chalk.Cyan.Color(RedactSecrets(message)))
 }
 
 func PrintWarning(message string) {
-	fmt.Println(chalk.Yellow.Color(message))
+	fmt.Println(chalk.Yellow.Color(RedactSecrets(message)))
 }
 
 func SPrintWarning(message string) string {
-	return fmt.Sprintln(chalk.Yellow.Color(message))
+	return fmt.Sprintln(chalk.Yellow.Color(RedactSecrets(message)))
 }
 
 func PrintErrorMessage(message string) {
-	fmt.Println(chalk.Red.Color(message))
+	fmt.Println(chalk.Red.Color(RedactSecrets(message)))
 }
 
 func SPrintErrorMessage(message string) string {
-	return fmt.Sprintln(chalk.Red.Color(message))
+	return fmt.Sprintln(chalk.Red.Color(RedactSecrets(message)))
 }
 
 func AskForConfirmation(message, defaultValue string) bool {
@@ -69,9 +69,19 @@ func AskForConfirmation(message, defaultValue string) bool {
 	}
 }
 
+// AskForInput prints a message and returns the line typed in response.
+func AskForInput(message string) string {
+	var response string
+
+	fmt.Println(message)
+	fmt.Scanln(&response)
+
+	return response
+}
+
 func PrintUnorderedList(list []string) {
 	for _, item := range list {
-		fmt.Println(chalk.Green, " • ", chalk.Reset, item)
+		fmt.Println(chalk.Green, " • ", chalk.Reset, RedactSecrets(item))
 	}
 }
 
@@ -79,7 +89,7 @@ func SPrintUnorderedList(list []string) string {
 	var output string = ""
 
 	for _, item := range list {
-		output = fmt.Sprintln(chalk.Green, " • ", chalk.Reset, item)
+		output += fmt.Sprintln(chalk.Green, " • ", chalk.Reset, RedactSecrets(item))
 	}
 
 	return output
@@ -87,7 +97,7 @@ func SPrintUnorderedList(list []string) string {
 
 func PrintOrderedList(list []string) {
 	for i, item := range list {
-		fmt.Println(chalk.Green, " ", i+1, ". ", chalk.Reset, item)
+		fmt.Println(chalk.Green, " ", i+1, ". ", chalk.Reset, RedactSecrets(item))
 	}
 }
 
@@ -95,7 +105,7 @@ func SPrintOrderedList(list []string) string {
 	var output string = ""
 
 	for i, item := range list {
-		output = fmt.Sprintln(chalk.Green, " ", i+1, ". ", chalk.Reset, item)
+		output += fmt.Sprintln(chalk.Green, " ", i+1, ". ", chalk.Reset, RedactSecrets(item))
 	}
 
 	return output
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+)
+
+// How long the migration lease of CockroachDB is held without being renewed,
+// a crashed monarch blocks the others for at most this long
+const migrationLeaseDuration = time.Minute
+
+// CockroachDB has no advisory locks, the migrations are locked by leasing the
+// single row of this table instead
+func createMigrationLeaseTable(db *sql.DB) *khata.Khata {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS migrations_lease
+		(
+			id INT8 PRIMARY KEY,
+			owner VARCHAR(255) NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL
+		)
+	`)
+
+	if err != nil {
+		return errors.FatalError.Wrap(err).Explain("Could not create migrations lease table")
+	}
+
+	return nil
+}
+
+// AcquireMigrationLock keeps other monarch processes from migrating the
+// database until the returned release function is called. Only CockroachDB is
+// locked, through a lease renewed in the background; the other dialects run
+// without a lock. The lease holds a connection of its own so renewals never
+// wait for the pool behind a long migration.
+func AcquireMigrationLock(ctx context.Context, db *sql.DB) (func(), *khata.Khata) {
+	if GetDialect(db) != "cockroach" {
+		return func() {}, nil
+	}
+
+	kErr := createMigrationLeaseTable(db)
+
+	if kErr != nil {
+		return nil, kErr
+	}
+
+	conn, err := db.Conn(ctx)
+
+	if err != nil {
+		return nil, wrapContextError(ctx, err, "Could not open a connection for the migration lease")
+	}
+
+	hostname, _ := os.Hostname()
+	owner := fmt.Sprintf("%s:%d:%d", hostname, os.Getpid(), time.Now().UnixNano())
+	lease := fmt.Sprintf("%d seconds", int(migrationLeaseDuration.Seconds()))
+
+	for attempt := 0; ; attempt++ {
+		// Take the lease when nobody holds it or when it expired
+		result, err := conn.ExecContext(ctx, `
+			INSERT INTO migrations_lease (id, owner, expires_at) VALUES (1, $1, now() + $2::INTERVAL)
+			ON CONFLICT (id) DO UPDATE SET owner = excluded.owner, expires_at = excluded.expires_at
+			WHERE migrations_lease.expires_at < now()
+		`, owner, lease)
+
+		if err != nil {
+			conn.Close()
+			return nil, wrapContextError(ctx, err, "Could not acquire the migration lease")
+		}
+
+		if acquired, _ := result.RowsAffected(); acquired > 0 {
+			break
+		}
+
+		delay := getRetryDelay(attempt)
+		PrintWarning(fmt.Sprintf("Another monarch holds the migration lease, retrying in %s", delay))
+
+		if !sleepContext(ctx, delay) {
+			conn.Close()
+			return nil, wrapContextError(ctx, ctx.Err(), "Could not acquire the migration lease")
+		}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(migrationLeaseDuration / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_, err := conn.ExecContext(
+					context.Background(),
+					"UPDATE migrations_lease SET expires_at = now() + $2::INTERVAL WHERE id = 1 AND owner = $1",
+					owner,
+					lease,
+				)
+
+				if err != nil {
+					PrintWarning("Could not renew the migration lease: " + err.Error())
+				}
+			}
+		}
+	}()
+
+	release := func() {
+		close(done)
+		<-stopped
+
+		_, err := conn.ExecContext(context.Background(), "DELETE FROM migrations_lease WHERE id = 1 AND owner = $1", owner)
+
+		if err != nil {
+			PrintWarning("Could not release the migration lease: " + err.Error())
+		}
+
+		conn.Close()
+	}
+
+	return release, nil
+}
+
+// LockMigrations takes the migration lock, then makes sure no other monarch
+// applied or rolled back a migration while it waited, since the migrations to
+// run were chosen from the applied ones read before.
+func LockMigrations(ctx context.Context, db *sql.DB, appliedMigrationKeys []string) (func(), *khata.Khata) {
+	release, kErr := AcquireMigrationLock(ctx, db)
+
+	if kErr != nil {
+		return nil, kErr.Explain("Error acquiring the migration lock")
+	}
+
+	currentMigrationKeys, kErr := GetMigrationsFromDatabase(db, true)
+
+	if kErr != nil {
+		release()
+		return nil, kErr.Explain("Error getting migrations from database")
+	}
+
+	isUnchanged := len(currentMigrationKeys) == len(appliedMigrationKeys)
+
+	for _, key := range currentMigrationKeys {
+		if FindIndexInString(appliedMigrationKeys, func(appliedKey string, _ int) bool { return appliedKey == key }) == -1 {
+			isUnchanged = false
+		}
+	}
+
+	if !isUnchanged {
+		release()
+		return nil, errors.FatalError.New("the database was migrated by another monarch in the meantime, run the command again")
+	}
+
+	return release, nil
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// registerPgxConnection registers a connection string with the pgx driver so
+// the NOTICE messages of the server, such as RAISE NOTICE in a migration, are
+// printed. It returns the name to open the connection by.
+func registerPgxConnection(connection string) (string, error) {
+	config, err := pgx.ParseConfig(connection)
+
+	if err != nil {
+		return "", err
+	}
+
+	config.OnNotice = func(_ *pgconn.PgConn, notice *pgconn.Notice) {
+		PrintInfo(notice.Severity + ": " + notice.Message)
+	}
+
+	return stdlib.RegisterConnConfig(config), nil
+}
@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"context"
+	"database/sql/driver"
+	stdErrors "errors"
+	"regexp"
+	"syscall"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+)
+
+const (
+	initialRetryDelay = 250 * time.Millisecond
+	maxRetryDelay     = 10 * time.Second
+)
+
+// PostgreSQL error codes worth retrying: serialization failure, deadlock,
+// lock not available, too many connections and the connection exceptions
+var transientPostgresCodes = []string{"40001", "40P01", "55P03", "53300", "08000", "08001", "08003", "08004", "08006", "57P01"}
+
+// MySQL error numbers worth retrying: lock wait timeout, deadlock and too
+// many connections
+var transientMysqlNumbers = []uint16{1205, 1213, 1040}
+
+// SQLite result codes worth retrying: SQLITE_BUSY and SQLITE_LOCKED
+var transientSqliteCodes = []int{5, 6}
+
+// Schema changes, which commit implicitly on MySQL and run outside of the
+// migration transaction on CockroachDB, so a failed migration containing them
+// cannot be rolled back and retried
+var implicitCommitRegexp = regexp.MustCompile(`(?i)^\s*(CREATE|ALTER|DROP|RENAME|TRUNCATE)\b`)
+
+// IsTransientError tells whether an error is likely to go away when the
+// migration is run again, as classified by each driver.
+func IsTransientError(err error) bool {
+	var pqErr *pq.Error
+
+	if stdErrors.As(err, &pqErr) {
+		return isTransientPostgresCode(string(pqErr.Code))
+	}
+
+	var pgErr *pgconn.PgError
+
+	if stdErrors.As(err, &pgErr) {
+		return isTransientPostgresCode(pgErr.Code)
+	}
+
+	var mysqlErr *mysql.MySQLError
+
+	if stdErrors.As(err, &mysqlErr) {
+		for _, number := range transientMysqlNumbers {
+			if mysqlErr.Number == number {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	var sqliteErr *sqlite.Error
+
+	if stdErrors.As(err, &sqliteErr) {
+		for _, code := range transientSqliteCodes {
+			// Extended result codes keep the primary code in the low byte
+			if sqliteErr.Code()&0xff == code {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return stdErrors.Is(err, driver.ErrBadConn) ||
+		stdErrors.Is(err, mysql.ErrInvalidConn) ||
+		stdErrors.Is(err, syscall.ECONNRESET) ||
+		stdErrors.Is(err, syscall.ECONNREFUSED)
+}
+
+func isTransientPostgresCode(code string) bool {
+	for _, transientCode := range transientPostgresCodes {
+		if code == transientCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isRetryableMigration tells whether a failed migration left the database
+// untouched, which is only the case when it ran in a transaction that could
+// roll all of it back.
+func isRetryableMigration(dialect string, noTransaction bool, content string) bool {
+	if noTransaction {
+		return false
+	}
+
+	// MySQL commits implicitly around DDL and CockroachDB runs it outside of
+	// the migration transaction
+	if dialect != "mysql" && dialect != "cockroach" {
+		return true
+	}
+
+	return !containsSchemaChange(content)
+}
+
+// containsSchemaChange tells whether a migration has DDL statements.
+func containsSchemaChange(content string) bool {
+	for _, statement := range SplitSqlStatements(content) {
+		if implicitCommitRegexp.MatchString(statement) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSerializationFailure tells whether an error is a PostgreSQL or CockroachDB
+// serialization failure, which asks the client to retry the transaction.
+func isSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+
+	if stdErrors.As(err, &pqErr) {
+		return pqErr.Code == "40001"
+	}
+
+	var pgErr *pgconn.PgError
+
+	return stdErrors.As(err, &pgErr) && pgErr.Code == "40001"
+}
+
+// getRetryDelay returns the exponential backoff before the given retry,
+// starting at zero.
+func getRetryDelay(attempt int) time.Duration {
+	delay := initialRetryDelay
+
+	for i := 0; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+
+	return delay
+}
+
+// sleepContext waits for the delay unless the context ends first.
+func sleepContext(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"database/sql/driver"
+	stdErrors "errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+)
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"pq serialization failure", &pq.Error{Code: "40001"}, true},
+		{"pq deadlock", &pq.Error{Code: "40P01"}, true},
+		{"pq syntax error", &pq.Error{Code: "42601"}, false},
+		{"pgx serialization failure", &pgconn.PgError{Code: "40001"}, true},
+		{"pgx connection failure", &pgconn.PgError{Code: "08006"}, true},
+		{"pgx unique violation", &pgconn.PgError{Code: "23505"}, false},
+		{"wrapped pgx lock not available", fmt.Errorf("migration: %w", &pgconn.PgError{Code: "55P03"}), true},
+		{"mysql deadlock", &mysql.MySQLError{Number: 1213}, true},
+		{"mysql lock wait timeout", &mysql.MySQLError{Number: 1205}, true},
+		{"mysql duplicate entry", &mysql.MySQLError{Number: 1062}, false},
+		{"bad connection", driver.ErrBadConn, true},
+		{"connection reset", fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{"other error", stdErrors.New("boom"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransientError(tt.err); got != tt.want {
+				t.Errorf("IsTransientError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsSerializationFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"pq", &pq.Error{Code: "40001"}, true},
+		{"pgx", &pgconn.PgError{Code: "40001"}, true},
+		{"wrapped pgx", fmt.Errorf("migration: %w", &pgconn.PgError{Code: "40001"}), true},
+		{"deadlock", &pgconn.PgError{Code: "40P01"}, false},
+		{"mysql deadlock", &mysql.MySQLError{Number: 1213}, false},
+		{"other error", stdErrors.New("40001"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSerializationFailure(tt.err); got != tt.want {
+				t.Errorf("isSerializationFailure(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRetryableMigration(t *testing.T) {
+	tests := []struct {
+		name          string
+		dialect       string
+		noTransaction bool
+		content       string
+		want          bool
+	}{
+		{"postgres schema change", "postgres", false, "CREATE TABLE a (id INT);", true},
+		{"postgres without transaction", "postgres", true, "INSERT INTO a VALUES (1);", false},
+		{"mysql data change", "mysql", false, "INSERT INTO a VALUES (1);", true},
+		{"mysql schema change", "mysql", false, "INSERT INTO a VALUES (1);\nALTER TABLE a ADD b INT;", false},
+		{"cockroach data change", "cockroach", false, "UPDATE a SET b = 1;", true},
+		{"cockroach schema change", "cockroach", false, "DROP TABLE a;", false},
+		{"cockroach schema change in a literal", "cockroach", false, "INSERT INTO a VALUES ('x;\nDROP TABLE a');", true},
+		{"cockroach schema change in a comment", "cockroach", false, "-- DROP TABLE a\nUPDATE a SET b = 1;", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableMigration(tt.dialect, tt.noTransaction, tt.content); got != tt.want {
+				t.Errorf("isRetryableMigration(%q, %v, %q) = %v, want %v", tt.dialect, tt.noTransaction, tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, initialRetryDelay},
+		{1, 2 * initialRetryDelay},
+		{3, 8 * initialRetryDelay},
+		{10, maxRetryDelay},
+		{100, maxRetryDelay},
+	}
+
+	for _, tt := range tests {
+		if got := getRetryDelay(tt.attempt); got != tt.want {
+			t.Errorf("getRetryDelay(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,765 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/types"
+)
+
+// Tables managed by monarch itself are left out of the introspected schema
+var trackingTables = []string{"migrations", "seeds", "migrations_lease"}
+
+var sqliteViewRegexp = regexp.MustCompile(`(?is)^CREATE\s+(TEMP\w*\s+)?VIEW\s+(IF\s+NOT\s+EXISTS\s+)?\S+\s+AS\s+(.*)$`)
+
+// IntrospectSchema reads the tables, columns, indexes, constraints and views
+// of the database through information_schema, the PostgreSQL catalog or
+// sqlite_master. The result is sorted so it can be dumped deterministically.
+func IntrospectSchema(db *sql.DB) (types.Schema, *khata.Khata) {
+	var schema types.Schema
+	var kErr *khata.Khata
+
+	switch GetDialect(db) {
+	case "mysql":
+		schema, kErr = introspectMysqlSchema(db)
+	case "postgres", "cockroach":
+		schema, kErr = introspectPostgresSchema(db)
+	case "sqlite":
+		schema, kErr = introspectSqliteSchema(db)
+	default:
+		return schema, errors.FatalError.New("schema introspection is not supported for this driver")
+	}
+
+	if kErr != nil {
+		return schema, kErr
+	}
+
+	sortSchema(&schema)
+
+	return schema, nil
+}
+
+func sortSchema(schema *types.Schema) {
+	sort.Slice(schema.Tables, func(i, j int) bool {
+		return schema.Tables[i].Name < schema.Tables[j].Name
+	})
+
+	for _, table := range schema.Tables {
+		sort.Slice(table.Indexes, func(i, j int) bool {
+			return table.Indexes[i].Name < table.Indexes[j].Name
+		})
+
+		sort.Slice(table.Constraints, func(i, j int) bool {
+			if table.Constraints[i].Type != table.Constraints[j].Type {
+				return table.Constraints[i].Type < table.Constraints[j].Type
+			}
+
+			return table.Constraints[i].Definition < table.Constraints[j].Definition
+		})
+	}
+
+	sort.Slice(schema.Views, func(i, j int) bool {
+		return schema.Views[i].Name < schema.Views[j].Name
+	})
+}
+
+func queryStrings(db *sql.DB, query string, args ...any) ([]string, *khata.Khata) {
+	rows, err := db.Query(query, args...)
+
+	if err != nil {
+		return nil, errors.FatalError.Wrap(err).Explain("Could not introspect schema")
+	}
+
+	defer rows.Close()
+
+	values := []string{}
+
+	for rows.Next() {
+		var value string
+
+		if err := rows.Scan(&value); err != nil {
+			return nil, errors.FatalError.Wrap(err).Explain("Could not scan schema row")
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func isTrackingTable(name string) bool {
+	return FindIndexInString(trackingTables, func(value string, _ int) bool { return value == name }) != -1
+}
+
+func introspectSqliteSchema(db *sql.DB) (types.Schema, *khata.Khata) {
+	schema := types.Schema{Dialect: "sqlite", Tables: []types.SchemaTable{}, Views: []types.SchemaView{}}
+
+	tableNames, kErr := queryStrings(db, "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'")
+
+	if kErr != nil {
+		return schema, kErr
+	}
+
+	for _, tableName := range tableNames {
+		if isTrackingTable(tableName) {
+			continue
+		}
+
+		table := types.SchemaTable{Name: tableName}
+
+		rows, err := db.Query(`SELECT name, type, "notnull", dflt_value, pk FROM pragma_table_info(?) ORDER BY cid`, tableName)
+
+		if err != nil {
+			return schema, errors.FatalError.Wrap(err).Explainf("Could not introspect columns of %s", tableName)
+		}
+
+		primaryKey := map[int]string{}
+
+		for rows.Next() {
+			var column types.SchemaColumn
+			var notNull bool
+			var defaultValue sql.NullString
+			var pk int
+
+			if err := rows.Scan(&column.Name, &column.Type, &notNull, &defaultValue, &pk); err != nil {
+				rows.Close()
+				return schema, errors.FatalError.Wrap(err).Explain("Could not scan schema row")
+			}
+
+			column.Nullable = !notNull
+			column.Default = defaultValue.String
+			table.Columns = append(table.Columns, column)
+
+			if pk > 0 {
+				primaryKey[pk] = column.Name
+			}
+		}
+
+		rows.Close()
+
+		if len(primaryKey) > 0 {
+			columns := []string{}
+			for i := 1; i <= len(primaryKey); i++ {
+				columns = append(columns, primaryKey[i])
+			}
+
+			table.Constraints = append(table.Constraints, types.SchemaConstraint{
+				Type:       "PRIMARY KEY",
+				Definition: "PRIMARY KEY (" + strings.Join(columns, ", ") + ")",
+			})
+		}
+
+		rows, err = db.Query(`SELECT name, "unique", origin FROM pragma_index_list(?)`, tableName)
+
+		if err != nil {
+			return schema, errors.FatalError.Wrap(err).Explainf("Could not introspect indexes of %s", tableName)
+		}
+
+		type sqliteIndex struct {
+			name   string
+			unique bool
+			origin string
+		}
+
+		indexes := []sqliteIndex{}
+
+		for rows.Next() {
+			var index sqliteIndex
+
+			if err := rows.Scan(&index.name, &index.unique, &index.origin); err != nil {
+				rows.Close()
+				return schema, errors.FatalError.Wrap(err).Explain("Could not scan schema row")
+			}
+
+			indexes = append(indexes, index)
+		}
+
+		rows.Close()
+
+		for _, index := range indexes {
+			// The primary key is already described by the table info
+			if index.origin == "pk" {
+				continue
+			}
+
+			columns, kErr := queryStrings(db, "SELECT name FROM pragma_index_info(?) ORDER BY seqno", index.name)
+
+			if kErr != nil {
+				return schema, kErr
+			}
+
+			// Indexes created by UNIQUE constraints are reported as constraints
+			if index.origin == "u" {
+				table.Constraints = append(table.Constraints, types.SchemaConstraint{
+					Type:       "UNIQUE",
+					Definition: "UNIQUE (" + strings.Join(columns, ", ") + ")",
+				})
+				continue
+			}
+
+			table.Indexes = append(table.Indexes, types.SchemaIndex{
+				Name:    index.name,
+				Columns: columns,
+				Unique:  index.unique,
+			})
+		}
+
+		rows, err = db.Query(`SELECT id, "table", "from", "to" FROM pragma_foreign_key_list(?) ORDER BY id, seq`, tableName)
+
+		if err != nil {
+			return schema, errors.FatalError.Wrap(err).Explainf("Could not introspect foreign keys of %s", tableName)
+		}
+
+		foreignKeyIds := []int{}
+		foreignKeys := map[int]*struct {
+			table string
+			from  []string
+			to    []string
+		}{}
+
+		for rows.Next() {
+			var id int
+			var referencedTable, from string
+			var to sql.NullString
+
+			if err := rows.Scan(&id, &referencedTable, &from, &to); err != nil {
+				rows.Close()
+				return schema, errors.FatalError.Wrap(err).Explain("Could not scan schema row")
+			}
+
+			if _, ok := foreignKeys[id]; !ok {
+				foreignKeyIds = append(foreignKeyIds, id)
+				foreignKeys[id] = &struct {
+					table string
+					from  []string
+					to    []string
+				}{table: referencedTable}
+			}
+
+			foreignKeys[id].from = append(foreignKeys[id].from, from)
+
+			if to.Valid {
+				foreignKeys[id].to = append(foreignKeys[id].to, to.String)
+			}
+		}
+
+		rows.Close()
+
+		for _, id := range foreignKeyIds {
+			foreignKey := foreignKeys[id]
+			definition := "FOREIGN KEY (" + strings.Join(foreignKey.from, ", ") + ") REFERENCES " + foreignKey.table
+
+			if len(foreignKey.to) > 0 {
+				definition += " (" + strings.Join(foreignKey.to, ", ") + ")"
+			}
+
+			table.Constraints = append(table.Constraints, types.SchemaConstraint{
+				Type:       "FOREIGN KEY",
+				Definition: definition,
+			})
+		}
+
+		schema.Tables = append(schema.Tables, table)
+	}
+
+	rows, err := db.Query("SELECT name, sql FROM sqlite_master WHERE type = 'view'")
+
+	if err != nil {
+		return schema, errors.FatalError.Wrap(err).Explain("Could not introspect views")
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var view types.SchemaView
+		var definition string
+
+		if err := rows.Scan(&view.Name, &definition); err != nil {
+			return schema, errors.FatalError.Wrap(err).Explain("Could not scan schema row")
+		}
+
+		if match := sqliteViewRegexp.FindStringSubmatch(definition); match != nil {
+			definition = match[3]
+		}
+
+		view.Definition = strings.TrimSpace(definition)
+		schema.Views = append(schema.Views, view)
+	}
+
+	return schema, nil
+}
+
+func introspectPostgresSchema(db *sql.DB) (types.Schema, *khata.Khata) {
+	schema := types.Schema{Dialect: GetDialect(db), Tables: []types.SchemaTable{}, Views: []types.SchemaView{}}
+
+	tableNames, kErr := queryStrings(db, `
+		SELECT table_name FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_type = 'BASE TABLE'
+	`)
+
+	if kErr != nil {
+		return schema, kErr
+	}
+
+	for _, tableName := range tableNames {
+		if isTrackingTable(tableName) {
+			continue
+		}
+
+		table := types.SchemaTable{Name: tableName}
+
+		rows, err := db.Query(`
+			SELECT a.attname, format_type(a.atttypid, a.atttypmod), NOT a.attnotnull,
+				COALESCE(pg_get_expr(d.adbin, d.adrelid), '')
+			FROM pg_attribute a
+			LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+			WHERE a.attrelid = to_regclass(quote_ident($1)) AND a.attnum > 0 AND NOT a.attisdropped
+			ORDER BY a.attnum
+		`, tableName)
+
+		if err != nil {
+			return schema, errors.FatalError.Wrap(err).Explainf("Could not introspect columns of %s", tableName)
+		}
+
+		for rows.Next() {
+			var column types.SchemaColumn
+
+			if err := rows.Scan(&column.Name, &column.Type, &column.Nullable, &column.Default); err != nil {
+				rows.Close()
+				return schema, errors.FatalError.Wrap(err).Explain("Could not scan schema row")
+			}
+
+			table.Columns = append(table.Columns, column)
+		}
+
+		rows.Close()
+
+		// CockroachDB adds a hidden rowid column to tables without a primary key
+		if schema.Dialect == "cockroach" {
+			hiddenColumnNames, kErr := queryStrings(db, `
+				SELECT column_name FROM information_schema.columns
+				WHERE table_schema = current_schema() AND table_name = $1 AND is_hidden = 'YES'
+			`, tableName)
+
+			if kErr != nil {
+				return schema, kErr
+			}
+
+			visibleColumns := []types.SchemaColumn{}
+
+			for _, column := range table.Columns {
+				if FindIndexInString(hiddenColumnNames, func(name string, _ int) bool { return name == column.Name }) == -1 {
+					visibleColumns = append(visibleColumns, column)
+				}
+			}
+
+			table.Columns = visibleColumns
+		}
+
+		rows, err = db.Query(`
+			SELECT conname, contype, pg_get_constraintdef(oid)
+			FROM pg_constraint
+			WHERE conrelid = to_regclass(quote_ident($1))
+		`, tableName)
+
+		if err != nil {
+			return schema, errors.FatalError.Wrap(err).Explainf("Could not introspect constraints of %s", tableName)
+		}
+
+		constraintTypes := map[string]string{
+			"p": "PRIMARY KEY",
+			"u": "UNIQUE",
+			"f": "FOREIGN KEY",
+			"c": "CHECK",
+			"x": "EXCLUDE",
+		}
+
+		for rows.Next() {
+			var constraint types.SchemaConstraint
+			var constraintType string
+
+			if err := rows.Scan(&constraint.Name, &constraintType, &constraint.Definition); err != nil {
+				rows.Close()
+				return schema, errors.FatalError.Wrap(err).Explain("Could not scan schema row")
+			}
+
+			constraint.Type = constraintTypes[constraintType]
+			table.Constraints = append(table.Constraints, constraint)
+		}
+
+		rows.Close()
+
+		// Indexes backing a constraint are described by the constraint. The
+		// columns come one per row, arrays do not scan the same way with every
+		// PostgreSQL driver.
+		rows, err = db.Query(`
+			SELECT i.relname, ix.indisunique, pg_get_indexdef(ix.indexrelid, k + 1, true)
+			FROM pg_index ix
+			JOIN pg_class i ON i.oid = ix.indexrelid
+			CROSS JOIN generate_subscripts(ix.indkey, 1) AS k
+			WHERE ix.indrelid = to_regclass(quote_ident($1))
+				AND NOT EXISTS (SELECT 1 FROM pg_constraint c WHERE c.conindid = ix.indexrelid)
+			ORDER BY i.relname, k
+		`, tableName)
+
+		if err != nil {
+			return schema, errors.FatalError.Wrap(err).Explainf("Could not introspect indexes of %s", tableName)
+		}
+
+		for rows.Next() {
+			var index types.SchemaIndex
+			var column string
+
+			if err := rows.Scan(&index.Name, &index.Unique, &column); err != nil {
+				rows.Close()
+				return schema, errors.FatalError.Wrap(err).Explain("Could not scan schema row")
+			}
+
+			if len(table.Indexes) > 0 && table.Indexes[len(table.Indexes)-1].Name == index.Name {
+				table.Indexes[len(table.Indexes)-1].Columns = append(table.Indexes[len(table.Indexes)-1].Columns, column)
+				continue
+			}
+
+			index.Columns = []string{column}
+			table.Indexes = append(table.Indexes, index)
+		}
+
+		rows.Close()
+
+		schema.Tables = append(schema.Tables, table)
+	}
+
+	rows, err := db.Query(`
+		SELECT table_name, view_definition FROM information_schema.views
+		WHERE table_schema = current_schema()
+	`)
+
+	if err != nil {
+		return schema, errors.FatalError.Wrap(err).Explain("Could not introspect views")
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var view types.SchemaView
+
+		if err := rows.Scan(&view.Name, &view.Definition); err != nil {
+			return schema, errors.FatalError.Wrap(err).Explain("Could not scan schema row")
+		}
+
+		view.Definition = strings.TrimSuffix(strings.TrimSpace(view.Definition), ";")
+		schema.Views = append(schema.Views, view)
+	}
+
+	return schema, nil
+}
+
+func introspectMysqlSchema(db *sql.DB) (types.Schema, *khata.Khata) {
+	schema := types.Schema{Dialect: "mysql", Tables: []types.SchemaTable{}, Views: []types.SchemaView{}}
+
+	tableNames, kErr := queryStrings(db, `
+		SELECT table_name FROM information_schema.tables
+		WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE'
+	`)
+
+	if kErr != nil {
+		return schema, kErr
+	}
+
+	for _, tableName := range tableNames {
+		if isTrackingTable(tableName) {
+			continue
+		}
+
+		table := types.SchemaTable{Name: tableName}
+
+		rows, err := db.Query(`
+			SELECT column_name,
+				CONCAT(column_type, IF(extra LIKE '%auto_increment%', ' AUTO_INCREMENT', '')),
+				is_nullable = 'YES', column_default
+			FROM information_schema.columns
+			WHERE table_schema = DATABASE() AND table_name = ?
+			ORDER BY ordinal_position
+		`, tableName)
+
+		if err != nil {
+			return schema, errors.FatalError.Wrap(err).Explainf("Could not introspect columns of %s", tableName)
+		}
+
+		for rows.Next() {
+			var column types.SchemaColumn
+			var defaultValue sql.NullString
+
+			if err := rows.Scan(&column.Name, &column.Type, &column.Nullable, &defaultValue); err != nil {
+				rows.Close()
+				return schema, errors.FatalError.Wrap(err).Explain("Could not scan schema row")
+			}
+
+			column.Default = defaultValue.String
+			table.Columns = append(table.Columns, column)
+		}
+
+		rows.Close()
+
+		rows, err = db.Query(`
+			SELECT index_name, non_unique = 0, column_name
+			FROM information_schema.statistics
+			WHERE table_schema = DATABASE() AND table_name = ?
+			ORDER BY index_name, seq_in_index
+		`, tableName)
+
+		if err != nil {
+			return schema, errors.FatalError.Wrap(err).Explainf("Could not introspect indexes of %s", tableName)
+		}
+
+		indexNames := []string{}
+		indexes := map[string]*types.SchemaIndex{}
+
+		for rows.Next() {
+			var name, column string
+			var unique bool
+
+			if err := rows.Scan(&name, &unique, &column); err != nil {
+				rows.Close()
+				return schema, errors.FatalError.Wrap(err).Explain("Could not scan schema row")
+			}
+
+			if _, ok := indexes[name]; !ok {
+				indexNames = append(indexNames, name)
+				indexes[name] = &types.SchemaIndex{Name: name, Unique: unique}
+			}
+
+			indexes[name].Columns = append(indexes[name].Columns, column)
+		}
+
+		rows.Close()
+
+		for _, name := range indexNames {
+			if name == "PRIMARY" {
+				table.Constraints = append(table.Constraints, types.SchemaConstraint{
+					Type:       "PRIMARY KEY",
+					Definition: "PRIMARY KEY (" + strings.Join(indexes[name].Columns, ", ") + ")",
+				})
+				continue
+			}
+
+			table.Indexes = append(table.Indexes, *indexes[name])
+		}
+
+		rows, err = db.Query(`
+			SELECT constraint_name, column_name, referenced_table_name, referenced_column_name
+			FROM information_schema.key_column_usage
+			WHERE table_schema = DATABASE() AND table_name = ? AND referenced_table_name IS NOT NULL
+			ORDER BY constraint_name, ordinal_position
+		`, tableName)
+
+		if err != nil {
+			return schema, errors.FatalError.Wrap(err).Explainf("Could not introspect foreign keys of %s", tableName)
+		}
+
+		foreignKeyNames := []string{}
+		foreignKeys := map[string]*struct {
+			table string
+			from  []string
+			to    []string
+		}{}
+
+		for rows.Next() {
+			var name, from, referencedTable, to string
+
+			if err := rows.Scan(&name, &from, &referencedTable, &to); err != nil {
+				rows.Close()
+				return schema, errors.FatalError.Wrap(err).Explain("Could not scan schema row")
+			}
+
+			if _, ok := foreignKeys[name]; !ok {
+				foreignKeyNames = append(foreignKeyNames, name)
+				foreignKeys[name] = &struct {
+					table string
+					from  []string
+					to    []string
+				}{table: referencedTable}
+			}
+
+			foreignKeys[name].from = append(foreignKeys[name].from, from)
+			foreignKeys[name].to = append(foreignKeys[name].to, to)
+		}
+
+		rows.Close()
+
+		for _, name := range foreignKeyNames {
+			foreignKey := foreignKeys[name]
+
+			table.Constraints = append(table.Constraints, types.SchemaConstraint{
+				Name: name,
+				Type: "FOREIGN KEY",
+				Definition: fmt.Sprintf(
+					"FOREIGN KEY (%s) REFERENCES %s (%s)",
+					strings.Join(foreignKey.from, ", "),
+					foreignKey.table,
+					strings.Join(foreignKey.to, ", "),
+				),
+			})
+		}
+
+		schema.Tables = append(schema.Tables, table)
+	}
+
+	rows, err := db.Query(`
+		SELECT table_name, view_definition FROM information_schema.views
+		WHERE table_schema = DATABASE()
+	`)
+
+	if err != nil {
+		return schema, errors.FatalError.Wrap(err).Explain("Could not introspect views")
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var view types.SchemaView
+
+		if err := rows.Scan(&view.Name, &view.Definition); err != nil {
+			return schema, errors.FatalError.Wrap(err).Explain("Could not scan schema row")
+		}
+
+		schema.Views = append(schema.Views, view)
+	}
+
+	return schema, nil
+}
+
+// RenderSchemaSQL renders the schema as DDL statements, tables first, then
+// their indexes and finally the views.
+func RenderSchemaSQL(schema types.Schema) string {
+	var output strings.Builder
+
+	for _, table := range orderTablesByDependencies(schema.Tables) {
+		output.WriteString(RenderCreateTableSQL(table))
+		output.WriteString("\n")
+
+		for _, index := range table.Indexes {
+			output.WriteString(RenderCreateIndexSQL(table.Name, index))
+			output.WriteString("\n")
+		}
+
+		if len(table.Indexes) > 0 {
+			output.WriteString("\n")
+		}
+	}
+
+	for _, view := range schema.Views {
+		output.WriteString(RenderCreateViewSQL(view))
+		output.WriteString("\n\n")
+	}
+
+	return strings.TrimRight(output.String(), "\n") + "\n"
+}
+
+var referencesRegexp = regexp.MustCompile(`(?i)REFERENCES\s+([^\s(]+)`)
+
+// orderTablesByDependencies orders the tables so every table comes after the
+// tables its foreign keys reference. Ties and cycles are broken by name.
+func orderTablesByDependencies(tables []types.SchemaTable) []types.SchemaTable {
+	ordered := []types.SchemaTable{}
+	emitted := map[string]bool{}
+	remaining := append([]types.SchemaTable{}, tables...)
+
+	for len(remaining) > 0 {
+		next := -1
+
+		for i, table := range remaining {
+			ready := true
+
+			for _, constraint := range table.Constraints {
+				for _, match := range referencesRegexp.FindAllStringSubmatch(constraint.Definition, -1) {
+					referenced := strings.Trim(match[1], `"`+"`")
+
+					if referenced == table.Name || emitted[referenced] {
+						continue
+					}
+
+					// Only wait for tables that are part of the schema
+					for _, other := range remaining {
+						if other.Name == referenced {
+							ready = false
+						}
+					}
+				}
+			}
+
+			if ready {
+				next = i
+				break
+			}
+		}
+
+		// A cycle, emit the first remaining table
+		if next == -1 {
+			next = 0
+		}
+
+		ordered = append(ordered, remaining[next])
+		emitted[remaining[next].Name] = true
+		remaining = append(remaining[:next], remaining[next+1:]...)
+	}
+
+	return ordered
+}
+
+// RenderColumnSQL renders the definition of a column as found in CREATE TABLE
+// and ALTER TABLE ... ADD COLUMN statements.
+func RenderColumnSQL(column types.SchemaColumn) string {
+	definition := column.Name + " " + column.Type
+
+	if !column.Nullable {
+		definition += " NOT NULL"
+	}
+
+	if column.Default != "" {
+		definition += " DEFAULT " + column.Default
+	}
+
+	return definition
+}
+
+func RenderConstraintSQL(constraint types.SchemaConstraint) string {
+	if constraint.Name != "" {
+		return "CONSTRAINT " + constraint.Name + " " + constraint.Definition
+	}
+
+	return constraint.Definition
+}
+
+func RenderCreateTableSQL(table types.SchemaTable) string {
+	lines := []string{}
+
+	for _, column := range table.Columns {
+		lines = append(lines, "    "+RenderColumnSQL(column))
+	}
+
+	for _, constraint := range table.Constraints {
+		lines = append(lines, "    "+RenderConstraintSQL(constraint))
+	}
+
+	return "CREATE TABLE " + table.Name + " (\n" + strings.Join(lines, ",\n") + "\n);\n"
+}
+
+func RenderCreateIndexSQL(tableName string, index types.SchemaIndex) string {
+	unique := ""
+
+	if index.Unique {
+		unique = "UNIQUE "
+	}
+
+	return "CREATE " + unique + "INDEX " + index.Name + " ON " + tableName + " (" + strings.Join(index.Columns, ", ") + ");"
+}
+
+func RenderCreateViewSQL(view types.SchemaView) string {
+	return "CREATE VIEW " + view.Name + " AS " + view.Definition + ";"
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/types"
+	"github.com/spf13/cobra"
+)
+
+// OpenScratchDatabase opens the throwaway database migrations are replayed
+// against. Without a connection string an in-memory SQLite database is used.
+func OpenScratchDatabase(driver string, connection string) (*sql.DB, *khata.Khata) {
+	driver, connection, kErr := ResolveConnection(driver, connection)
+
+	if kErr != nil {
+		return nil, kErr.Explain("Could not parse scratch connection string")
+	}
+
+	if driver == "" {
+		driver = "sqlite"
+	}
+
+	if driver == "sqlite" && connection == "" {
+		db, kErr := ConnectToDatabase(driver, ":memory:")
+
+		if kErr != nil {
+			return nil, kErr
+		}
+
+		// Every connection to :memory: is a distinct database
+		db.SetMaxOpenConns(1)
+
+		return db, nil
+	}
+
+	if connection == "" {
+		return nil, errors.FatalError.New("a scratch connection string is required for driver " + driver)
+	}
+
+	return ConnectToDatabase(driver, connection)
+}
+
+// OpenScratchDatabaseArg opens the scratch database described by the
+// --scratch-driver and --scratch-connection flags.
+func OpenScratchDatabaseArg(cmd *cobra.Command) (*sql.DB, *khata.Khata) {
+	return OpenScratchDatabase(
+		GetStringArg(cmd, "scratch-driver", "MONARCH_SCRATCH_DRIVER", ""),
+		GetStringArg(cmd, "scratch-connection", "MONARCH_SCRATCH_CONNECTION_STRING", ""),
+	)
+}
+
+// ApplyMigrationsToDatabase renders and runs the migrations in order without
+// recording them, which is how scratch databases are built.
+func ApplyMigrationsToDatabase(
+	ctx context.Context,
+	db *sql.DB,
+	migrationDir string,
+	migrationObjects []types.MigrationObject,
+	vars map[string]string,
+) *khata.Khata {
+	for _, migrationObject := range migrationObjects {
+		fileContent, kErr := GetRenderedMigrationContent(migrationDir, migrationObject.File, vars)
+
+		if kErr != nil {
+			return kErr.Explainf("Error getting migration content: %s", migrationObject.File)
+		}
+
+		kErr = RunMigration(ctx, db, migrationObject, fileContent)
+
+		if kErr != nil {
+			return kErr.Explainf("Error running migration: %s", migrationObject.File)
+		}
+	}
+
+	return nil
+}
+
+// ResetScratchDatabase drops every view and table of the scratch database so
+// it can be reused for another schema.
+func ResetScratchDatabase(db *sql.DB) *khata.Khata {
+	schema, kErr := IntrospectSchema(db)
+
+	if kErr != nil {
+		return kErr
+	}
+
+	for _, view := range schema.Views {
+		if _, err := db.Exec("DROP VIEW " + view.Name); err != nil {
+			return errors.FatalError.Wrap(err).Explainf("Could not drop view %s", view.Name)
+		}
+	}
+
+	// Drop the referencing tables before the tables they reference
+	tables := orderTablesByDependencies(schema.Tables)
+
+	for i := len(tables) - 1; i >= 0; i-- {
+		if _, err := db.Exec("DROP TABLE " + tables[i].Name); err != nil {
+			return errors.FatalError.Wrap(err).Explainf("Could not drop table %s", tables[i].Name)
+		}
+	}
+
+	return nil
+}
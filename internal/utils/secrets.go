@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"bytes"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/types"
+	"github.com/go-sql-driver/mysql"
+	"github.com/spf13/cobra"
+)
+
+const redactedSecret = "***"
+
+// Secrets shorter than this are only redacted through the DSN patterns,
+// replacing every occurrence of a couple of characters would garble output
+const minRegisteredSecretLength = 4
+
+var registeredSecrets = []string{}
+var registeredSecretsMutex sync.Mutex
+
+// Passwords as they appear in connection strings: URL userinfo, key/value
+// DSNs and MySQL's user:pass@tcp(host) syntax
+var urlPasswordRegexp = regexp.MustCompile(`(://[^:/@\s]*:)[^@\s]*(@)`)
+var keyValuePasswordRegexp = regexp.MustCompile(`(?i)(\bpassword\s*=\s*)('(?:[^'\\]|\\.)*'|[^\s&]+)`)
+var mysqlPasswordRegexp = regexp.MustCompile(`((?:^|[\s"'(])[^:@/\s"'(]+:)[^@\s]*(@(?:tcp|unix)?\()`)
+
+// RegisterSecret makes every printed message hide the secret.
+func RegisterSecret(secret string) {
+	if len(secret) < minRegisteredSecretLength {
+		return
+	}
+
+	registeredSecretsMutex.Lock()
+	defer registeredSecretsMutex.Unlock()
+
+	registeredSecrets = append(registeredSecrets, secret)
+}
+
+// RedactSecrets hides the passwords of connection strings and the registered
+// secrets in a message before it is printed.
+func RedactSecrets(message string) string {
+	message = urlPasswordRegexp.ReplaceAllString(message, "${1}"+redactedSecret+"${2}")
+	message = keyValuePasswordRegexp.ReplaceAllString(message, "${1}"+redactedSecret)
+	message = mysqlPasswordRegexp.ReplaceAllString(message, "${1}"+redactedSecret+"${2}")
+
+	registeredSecretsMutex.Lock()
+	defer registeredSecretsMutex.Unlock()
+
+	for _, secret := range registeredSecrets {
+		message = strings.ReplaceAll(message, secret, redactedSecret)
+	}
+
+	return message
+}
+
+// GetConnectionString returns the connection string from, in order, the
+// --connection flag, the environment section of the config (connection,
+// connection_file then connection_command), MONARCH_CONNECTION_STRING and
+// MONARCH_CONNECTION_STRING_FILE.
+func GetConnectionString(cmd *cobra.Command, envConfig types.EnvironmentConfig) (string, *khata.Khata) {
+	if connection := GetStringArg(cmd, "connection", "", envConfig.Connection); connection != "" {
+		return connection, nil
+	}
+
+	if envConfig.ConnectionFile != "" {
+		return readSecretFile(ResolveConfigRelativePath(envConfig.ConnectionFile))
+	}
+
+	if envConfig.ConnectionCommand != "" {
+		return runConnectionCommand(envConfig.ConnectionCommand)
+	}
+
+	if connection := GetEnv("MONARCH_CONNECTION_STRING"); connection != "" {
+		return connection, nil
+	}
+
+	if connectionFile := GetEnv("MONARCH_CONNECTION_STRING_FILE"); connectionFile != "" {
+		return readSecretFile(connectionFile)
+	}
+
+	return "", nil
+}
+
+// GetConnectionPassword returns the password kept apart from the connection
+// string, from the password_file of the config or MONARCH_PASSWORD_FILE.
+func GetConnectionPassword(envConfig types.EnvironmentConfig) (string, *khata.Khata) {
+	if envConfig.PasswordFile != "" {
+		return readSecretFile(ResolveConfigRelativePath(envConfig.PasswordFile))
+	}
+
+	if passwordFile := GetEnv("MONARCH_PASSWORD_FILE"); passwordFile != "" {
+		return readSecretFile(passwordFile)
+	}
+
+	return "", nil
+}
+
+// SetConnectionPassword puts a password into the DSN of a driver.
+func SetConnectionPassword(driver string, dsn string, password string) (string, *khata.Khata) {
+	switch GetDriverDialect(driver) {
+	case "mysql":
+		config, err := mysql.ParseDSN(dsn)
+
+		if err != nil {
+			return "", errors.FatalError.Wrap(err).Explain("Could not parse MySQL connection string")
+		}
+
+		config.Passwd = password
+
+		return config.FormatDSN(), nil
+	case "postgres", "cockroach":
+		if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+			u, err := url.Parse(dsn)
+
+			if err != nil {
+				return "", errors.FatalError.Wrap(err).Explain("Could not parse PostgreSQL connection URL")
+			}
+
+			u.User = url.UserPassword(u.User.Username(), password)
+
+			return u.String(), nil
+		}
+
+		escaped := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(password)
+
+		return dsn + " password='" + escaped + "'", nil
+	}
+
+	return "", errors.FatalError.New("a password file is not supported by driver " + driver)
+}
+
+// RegisterConnectionSecrets registers the password found in a DSN so driver
+// errors quoting it are redacted too.
+func RegisterConnectionSecrets(driver string, dsn string) {
+	switch GetDriverDialect(driver) {
+	case "mysql":
+		if config, err := mysql.ParseDSN(dsn); err == nil {
+			RegisterSecret(config.Passwd)
+		}
+	case "postgres", "cockroach":
+		if u, err := url.Parse(dsn); err == nil && u.User != nil {
+			password, _ := u.User.Password()
+			RegisterSecret(password)
+		}
+
+		if match := keyValuePasswordRegexp.FindStringSubmatch(dsn); match != nil {
+			RegisterSecret(strings.Trim(match[2], "'"))
+		}
+	}
+}
+
+// readSecretFile reads a secret mounted as a file, such as a Docker or
+// Kubernetes secret, without its trailing newline.
+func readSecretFile(secretPath string) (string, *khata.Khata) {
+	content, err := os.ReadFile(secretPath)
+
+	if err != nil {
+		return "", errors.FatalError.Wrap(err).Explainf("Could not read secret file %s", secretPath)
+	}
+
+	secret := strings.TrimSpace(string(content))
+	RegisterSecret(secret)
+
+	return secret, nil
+}
+
+// runConnectionCommand runs a local helper through the shell and reads the
+// connection string from its output.
+func runConnectionCommand(command string) (string, *khata.Khata) {
+	var helper *exec.Cmd
+
+	if runtime.GOOS == "windows" {
+		helper = exec.Command("cmd", "/C", command)
+	} else {
+		helper = exec.Command("sh", "-c", command)
+	}
+
+	var stdout bytes.Buffer
+
+	helper.Stdout = &stdout
+	helper.Stderr = os.Stderr
+
+	if err := helper.Run(); err != nil {
+		return "", errors.FatalError.Wrap(err).Explain("Could not run connection command")
+	}
+
+	connection := strings.TrimSpace(stdout.String())
+
+	if connection == "" {
+		return "", errors.FatalError.New("connection command printed no connection string")
+	}
+
+	RegisterSecret(connection)
+
+	return connection, nil
+}
+
+// ResolveConfigRelativePath resolves a path of the config file against the
+// directory of the config file.
+func ResolveConfigRelativePath(configRelativePath string) string {
+	if path.IsAbs(configRelativePath) {
+		return configRelativePath
+	}
+
+	configPath, kErr := GetConfigPath()
+
+	if kErr != nil {
+		return configRelativePath
+	}
+
+	return path.Join(path.Dir(configPath), configRelativePath)
+}
@@ -0,0 +1,110 @@
+package utils
+
+import "testing"
+
+func TestRedactSecrets(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{
+			name:    "url",
+			message: `dial postgres://app:s3cret@db:5432/app failed`,
+			want:    `dial postgres://app:***@db:5432/app failed`,
+		},
+		{
+			name:    "key value",
+			message: `host=db user=app password=s3cret dbname=app`,
+			want:    `host=db user=app password=*** dbname=app`,
+		},
+		{
+			name:    "quoted key value",
+			message: `host=db password='s3 \'cret' dbname=app`,
+			want:    `host=db password=*** dbname=app`,
+		},
+		{
+			name:    "url parameter",
+			message: `postgres://db/app?user=app&password=s3cret&sslmode=disable`,
+			want:    `postgres://db/app?user=app&password=***&sslmode=disable`,
+		},
+		{
+			name:    "mysql dsn",
+			message: `connecting to "app:s3cret@tcp(db:3306)/app"`,
+			want:    `connecting to "app:***@tcp(db:3306)/app"`,
+		},
+		{
+			name:    "url without password",
+			message: `postgres://app@db/app`,
+			want:    `postgres://app@db/app`,
+		},
+		{
+			name:    "no connection string",
+			message: `migration 0001-users: done`,
+			want:    `migration 0001-users: done`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RedactSecrets(tt.message); got != tt.want {
+				t.Errorf("RedactSecrets(%q) = %q, want %q", tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterSecret(t *testing.T) {
+	t.Cleanup(func() { registeredSecrets = []string{} })
+
+	RegisterSecret("hunter22")
+	RegisterSecret("ab")
+
+	want := `auth failed for *** at ab`
+
+	if got := RedactSecrets(`auth failed for hunter22 at ab`); got != want {
+		t.Errorf("RedactSecrets = %q, want %q", got, want)
+	}
+}
+
+func TestRegisterConnectionSecrets(t *testing.T) {
+	t.Cleanup(func() { registeredSecrets = []string{} })
+
+	RegisterConnectionSecrets("postgres", "postgres://app:url-secret@db/app")
+	RegisterConnectionSecrets("postgres", "host=db password='kv-secret'")
+	RegisterConnectionSecrets("mysql", "app:mysql-secret@tcp(db:3306)/app")
+
+	want := `*** *** ***`
+
+	if got := RedactSecrets(`url-secret kv-secret mysql-secret`); got != want {
+		t.Errorf("RedactSecrets = %q, want %q", got, want)
+	}
+}
+
+func TestSetConnectionPassword(t *testing.T) {
+	tests := []struct {
+		driver string
+		dsn    string
+		want   string
+	}{
+		{"postgres", "postgres://app@db/app", "postgres://app:p%40ss@db/app"},
+		{"postgres", "host=db user=app", `host=db user=app password='p@ss'`},
+		{"mysql", "app@tcp(db:3306)/app", "app:p@ss@tcp(db:3306)/app"},
+	}
+
+	for _, tt := range tests {
+		got, kErr := SetConnectionPassword(tt.driver, tt.dsn, "p@ss")
+
+		if kErr != nil {
+			t.Fatalf("SetConnectionPassword(%q, %q): %v", tt.driver, tt.dsn, kErr)
+		}
+
+		if got != tt.want {
+			t.Errorf("SetConnectionPassword(%q, %q) = %q, want %q", tt.driver, tt.dsn, got, tt.want)
+		}
+	}
+
+	if _, kErr := SetConnectionPassword("sqlite", "app.sqlite", "p@ss"); kErr == nil {
+		t.Error("set a password on a sqlite connection")
+	}
+}
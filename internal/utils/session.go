@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+)
+
+// sessionConnector opens connections with a driver and runs the session
+// statements of the environment on each of them, so whatever connection of
+// the pool a migration runs on has the same search path, role or timeouts.
+type sessionConnector struct {
+	driver     driver.Driver
+	dsn        string
+	statements []string
+}
+
+func (c *sessionConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	execer, ok := conn.(driver.ExecerContext)
+
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("the driver does not support session statements")
+	}
+
+	for _, statement := range c.statements {
+		_, err = execer.ExecContext(ctx, statement, nil)
+
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("session statement %s: %w", statement, err)
+		}
+	}
+
+	return conn, nil
+}
+
+func (c *sessionConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// OpenDatabase opens a database whose connections all run the session
+// statements when they are opened.
+func OpenDatabase(driverName string, connection string, sessionStatements []string) (*sql.DB, error) {
+	if driverName == "pgx" || driverName == "cockroach" {
+		var err error
+
+		connection, err = registerPgxConnection(connection)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := sql.Open(driverName, connection)
+
+	if err != nil || len(sessionStatements) == 0 {
+		return db, err
+	}
+
+	// sql.Open does not connect, it is only used to look up the driver
+	connector := &sessionConnector{
+		driver:     db.Driver(),
+		dsn:        connection,
+		statements: sessionStatements,
+	}
+
+	db.Close()
+
+	return sql.OpenDB(connector), nil
+}
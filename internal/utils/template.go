@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/spf13/cobra"
+)
+
+var shellVariableRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// GetTemplateVars collects the values available to migration templates. The
+// process environment (including the .env file) is overridden by the vars of
+// the environment section of the config, which are overridden by --var flags.
+func GetTemplateVars(cmd *cobra.Command) (map[string]string, *khata.Khata) {
+	vars := map[string]string{}
+
+	for _, entry := range os.Environ() {
+		key, value, _ := strings.Cut(entry, "=")
+		vars[key] = value
+	}
+
+	config, kErr := LoadConfig()
+
+	if kErr != nil {
+		return nil, kErr
+	}
+
+	for key, value := range GetEnvironmentConfig(config, GetEnvironmentArg(cmd)).Vars {
+		vars[key] = value
+	}
+
+	if cmd != nil && cmd.Flags().Lookup("var") != nil {
+		flagVars, _ := cmd.Flags().GetStringArray("var")
+
+		for _, flagVar := range flagVars {
+			key, value, found := strings.Cut(flagVar, "=")
+
+			if !found || key == "" {
+				return nil, errors.FatalError.New("invalid var, expected key=value: " + flagVar)
+			}
+
+			vars[key] = value
+		}
+	}
+
+	return vars, nil
+}
+
+// RenderMigration expands the text/template actions, then the ${NAME}
+// placeholders of a migration carrying the template directive. Other
+// migrations are returned as they are. Undefined variables are an error.
+func RenderMigration(content string, vars map[string]string) (string, *khata.Khata) {
+	directives, kErr := ParseMigrationDirectives(content)
+
+	if kErr != nil {
+		return "", kErr
+	}
+
+	if !directives.Template {
+		return content, nil
+	}
+
+	// The values are only substituted once the template ran, so a value
+	// containing {{ is never executed
+	if strings.Contains(content, "{{") {
+		tmpl, err := template.New("migration").Option("missingkey=error").Parse(content)
+
+		if err != nil {
+			return "", errors.FatalError.Wrap(err).Explain("Could not parse migration template")
+		}
+
+		var rendered strings.Builder
+
+		err = tmpl.Execute(&rendered, map[string]map[string]string{
+			"Env":  vars,
+			"Vars": vars,
+		})
+
+		if err != nil {
+			return "", errors.FatalError.Wrap(err).Explain("Could not render migration template")
+		}
+
+		content = rendered.String()
+	}
+
+	var missing []string
+
+	content = shellVariableRegexp.ReplaceAllStringFunc(content, func(match string) string {
+		name := shellVariableRegexp.FindStringSubmatch(match)[1]
+		value, ok := vars[name]
+
+		if !ok {
+			missing = append(missing, name)
+		}
+
+		return value
+	})
+
+	if len(missing) > 0 {
+		return "", errors.FatalError.New("undefined variables: " + strings.Join(missing, ", "))
+	}
+
+	return content, nil
+}
+
+// GetRenderedMigrationContent reads a migration and renders its template.
+func GetRenderedMigrationContent(migrationDir, file string, vars map[string]string) (string, *khata.Khata) {
+	return GetRenderedMigrationContentFromFS(os.DirFS(migrationDir), file, vars)
+}
+
+// GetRenderedMigrationContentFromFS reads a migration from a file system and
+// renders its template.
+func GetRenderedMigrationContentFromFS(fsys fs.FS, file string, vars map[string]string) (string, *khata.Khata) {
+	content, kErr := GetMigrationContentFromFS(fsys, file)
+
+	if kErr != nil {
+		return "", kErr
+	}
+
+	rendered, kErr := RenderMigration(content, vars)
+
+	if kErr != nil {
+		return "", kErr.Explainf("Could not render migration %s", file)
+	}
+
+	return rendered, nil
+}
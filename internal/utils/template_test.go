@@ -0,0 +1,60 @@
+package utils
+
+import "testing"
+
+func TestRenderMigration(t *testing.T) {
+	vars := map[string]string{"SCHEMA": "app", "OWNER": "{{.Vars.SCHEMA}}"}
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "without the directive",
+			content: "INSERT INTO a VALUES ('{{1,2}}', '${SCHEMA}', '${MISSING}');",
+			want:    "INSERT INTO a VALUES ('{{1,2}}', '${SCHEMA}', '${MISSING}');",
+		},
+		{
+			name:    "placeholders",
+			content: "-- monarch:template\nCREATE SCHEMA ${SCHEMA};",
+			want:    "-- monarch:template\nCREATE SCHEMA app;",
+		},
+		{
+			name:    "actions",
+			content: "-- monarch:template\n{{if .Vars.SCHEMA}}CREATE SCHEMA {{.Env.SCHEMA}};{{end}}",
+			want:    "-- monarch:template\nCREATE SCHEMA app;",
+		},
+		{
+			name:    "value looking like an action",
+			content: "-- monarch:template\nALTER SCHEMA ${SCHEMA} OWNER TO \"${OWNER}\";",
+			want:    "-- monarch:template\nALTER SCHEMA app OWNER TO \"{{.Vars.SCHEMA}}\";",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, kErr := RenderMigration(tt.content, vars)
+
+			if kErr != nil {
+				t.Fatalf("RenderMigration(%q): %v", tt.content, kErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("RenderMigration(%q) = %q, want %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderMigrationErrors(t *testing.T) {
+	for _, content := range []string{
+		"-- monarch:template\nCREATE SCHEMA ${MISSING};",
+		"-- monarch:template\nCREATE SCHEMA {{.Vars.MISSING}};",
+		"-- monarch:template\nCREATE SCHEMA {{.Vars.SCHEMA;",
+	} {
+		if _, kErr := RenderMigration(content, map[string]string{"SCHEMA": "app"}); kErr == nil {
+			t.Errorf("RenderMigration(%q) succeeded, want an error", content)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	stdErrors "errors"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/types"
+	"github.com/go-sql-driver/mysql"
+)
+
+// Name the TLS config of the environment is registered under with the MySQL
+// driver, which only references TLS configs by name in a DSN
+const mysqlTLSConfigName = "monarch"
+
+var supportedTLSModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// SetConnectionTLS applies the TLS settings of an environment to a DSN. The
+// certificate paths are relative to the config file.
+func SetConnectionTLS(driver string, dsn string, tlsConfig types.TLSConfig) (string, *khata.Khata) {
+	if tlsConfig.Mode == "" {
+		tlsConfig.Mode = "verify-full"
+	}
+
+	if !IsDriverSupported(tlsConfig.Mode, supportedTLSModes) {
+		return "", errors.FatalError.New("unsupported tls mode: " + tlsConfig.Mode)
+	}
+
+	if tlsConfig.CA != "" {
+		tlsConfig.CA = ResolveConfigRelativePath(tlsConfig.CA)
+	}
+
+	if tlsConfig.Cert != "" {
+		tlsConfig.Cert = ResolveConfigRelativePath(tlsConfig.Cert)
+	}
+
+	if tlsConfig.Key != "" {
+		tlsConfig.Key = ResolveConfigRelativePath(tlsConfig.Key)
+	}
+
+	if (tlsConfig.Cert == "") != (tlsConfig.Key == "") {
+		return "", errors.FatalError.New("tls cert and key must be set together")
+	}
+
+	switch GetDriverDialect(driver) {
+	case "mysql":
+		return setMysqlTLS(dsn, tlsConfig)
+	case "postgres", "cockroach":
+		return setPostgresTLS(dsn, tlsConfig)
+	}
+
+	return "", errors.FatalError.New("tls is not supported by driver " + driver)
+}
+
+func setPostgresTLS(dsn string, tlsConfig types.TLSConfig) (string, *khata.Khata) {
+	if tlsConfig.ServerName != "" {
+		return "", errors.FatalError.New("tls server_name is not supported by driver postgres, the host is verified")
+	}
+
+	params := [][2]string{{"sslmode", tlsConfig.Mode}}
+
+	if tlsConfig.CA != "" {
+		params = append(params, [2]string{"sslrootcert", tlsConfig.CA})
+	}
+
+	if tlsConfig.Cert != "" {
+		params = append(params, [2]string{"sslcert", tlsConfig.Cert}, [2]string{"sslkey", tlsConfig.Key})
+	}
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+
+		if err != nil {
+			return "", errors.FatalError.Wrap(err).Explain("Could not parse PostgreSQL connection URL")
+		}
+
+		query := u.Query()
+
+		for _, param := range params {
+			query.Set(param[0], param[1])
+		}
+
+		u.RawQuery = query.Encode()
+
+		return u.String(), nil
+	}
+
+	escaper := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+	for _, param := range params {
+		dsn += " " + param[0] + "='" + escaper.Replace(param[1]) + "'"
+	}
+
+	return strings.TrimSpace(dsn), nil
+}
+
+func setMysqlTLS(dsn string, tlsConfig types.TLSConfig) (string, *khata.Khata) {
+	config, err := mysql.ParseDSN(dsn)
+
+	if err != nil {
+		return "", errors.FatalError.Wrap(err).Explain("Could not parse MySQL connection string")
+	}
+
+	if tlsConfig.Mode == "disable" {
+		config.TLSConfig = "false"
+		return config.FormatDSN(), nil
+	}
+
+	clientTLSConfig := &tls.Config{ServerName: tlsConfig.ServerName}
+
+	if tlsConfig.CA != "" {
+		caContent, err := os.ReadFile(tlsConfig.CA)
+
+		if err != nil {
+			return "", errors.FatalError.Wrap(err).Explainf("Could not read tls ca %s", tlsConfig.CA)
+		}
+
+		clientTLSConfig.RootCAs = x509.NewCertPool()
+
+		if !clientTLSConfig.RootCAs.AppendCertsFromPEM(caContent) {
+			return "", errors.FatalError.New("no certificate found in tls ca " + tlsConfig.CA)
+		}
+	}
+
+	if tlsConfig.Cert != "" {
+		certificate, err := tls.LoadX509KeyPair(tlsConfig.Cert, tlsConfig.Key)
+
+		if err != nil {
+			return "", errors.FatalError.Wrap(err).Explain("Could not load tls cert and key")
+		}
+
+		clientTLSConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	switch tlsConfig.Mode {
+	case "require":
+		clientTLSConfig.InsecureSkipVerify = true
+	case "verify-ca":
+		// Go has no option to skip only the host name check, so the chain is
+		// verified by hand against the CA
+		clientTLSConfig.InsecureSkipVerify = true
+		clientTLSConfig.VerifyPeerCertificate = verifyCertificateChain(clientTLSConfig.RootCAs)
+	}
+
+	err = mysql.RegisterTLSConfig(mysqlTLSConfigName, clientTLSConfig)
+
+	if err != nil {
+		return "", errors.FatalError.Wrap(err).Explain("Could not register tls config")
+	}
+
+	config.TLSConfig = mysqlTLSConfigName
+
+	return config.FormatDSN(), nil
+}
+
+// verifyCertificateChain returns a verifier checking the server certificate
+// chains up to the CA, whatever the host name it was issued for.
+func verifyCertificateChain(rootCAs *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		certificates := make([]*x509.Certificate, len(rawCerts))
+
+		for i, rawCert := range rawCerts {
+			certificate, err := x509.ParseCertificate(rawCert)
+
+			if err != nil {
+				return err
+			}
+
+			certificates[i] = certificate
+		}
+
+		if len(certificates) == 0 {
+			return stdErrors.New("server sent no certificate")
+		}
+
+		intermediates := x509.NewCertPool()
+
+		for _, certificate := range certificates[1:] {
+			intermediates.AddCert(certificate)
+		}
+
+		_, err := certificates[0].Verify(x509.VerifyOptions{
+			Roots:         rootCAs,
+			Intermediates: intermediates,
+		})
+
+		return err
+	}
+}
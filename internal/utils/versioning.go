@@ -0,0 +1,269 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/types"
+)
+
+var numericVersionRegexp = regexp.MustCompile(`^(\d+)-`)
+var semanticVersionRegexp = regexp.MustCompile(`^V(\d+(?:[._]\d+)*)__`)
+
+var supportedVersioningSchemes = []string{"timestamp", "utc", "sequential", "semantic"}
+
+// ParseMigrationVersion extracts the version of a migration key. Timestamp
+// and sequential keys have a single component, semantic keys (V1_2__name)
+// have one component per number.
+func ParseMigrationVersion(key string) ([]int64, bool) {
+	if match := numericVersionRegexp.FindStringSubmatch(key); match != nil {
+		version, err := strconv.ParseInt(match[1], 10, 64)
+
+		if err != nil {
+			return nil, false
+		}
+
+		return []int64{version}, true
+	}
+
+	if match := semanticVersionRegexp.FindStringSubmatch(key); match != nil {
+		parts := strings.FieldsFunc(match[1], func(r rune) bool { return r == '.' || r == '_' })
+		version := []int64{}
+
+		for _, part := range parts {
+			number, err := strconv.ParseInt(part, 10, 64)
+
+			if err != nil {
+				return nil, false
+			}
+
+			version = append(version, number)
+		}
+
+		return version, true
+	}
+
+	return nil, false
+}
+
+// CompareMigrationKeys orders two migration keys by their parsed version and
+// falls back to comparing the raw keys when a version cannot be parsed or
+// both versions are equal.
+func CompareMigrationKeys(a, b string) int {
+	versionA, okA := ParseMigrationVersion(a)
+	versionB, okB := ParseMigrationVersion(b)
+
+	if okA && okB {
+		if result := CompareMigrationVersions(versionA, versionB); result != 0 {
+			return result
+		}
+	}
+
+	return strings.Compare(a, b)
+}
+
+// CompareMigrationVersions orders two parsed migration versions.
+func CompareMigrationVersions(a, b []int64) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] < b[i] {
+			return -1
+		}
+
+		if a[i] > b[i] {
+			return 1
+		}
+	}
+
+	if len(a) < len(b) {
+		return -1
+	}
+
+	if len(a) > len(b) {
+		return 1
+	}
+
+	return 0
+}
+
+// GetNextMigrationPrefix returns the prefix of a new migration file for the
+// versioning scheme, given the keys of the existing migrations.
+func GetNextMigrationPrefix(scheme string, existingKeys []string, now time.Time, explicitVersion string) (string, *khata.Khata) {
+	switch scheme {
+	case "", "timestamp":
+		return now.Format("20060102150405") + "-", nil
+	case "utc":
+		return now.UTC().Format("20060102150405") + "-", nil
+	case "sequential":
+		var latest int64
+		width := 4
+
+		for _, key := range existingKeys {
+			match := numericVersionRegexp.FindStringSubmatch(key)
+
+			if match == nil {
+				continue
+			}
+
+			version, err := strconv.ParseInt(match[1], 10, 64)
+
+			if err != nil {
+				continue
+			}
+
+			if version > latest {
+				latest = version
+			}
+
+			if len(match[1]) > width {
+				width = len(match[1])
+			}
+		}
+
+		return fmt.Sprintf("%0*d-", width, latest+1), nil
+	case "semantic":
+		if explicitVersion != "" {
+			if !regexp.MustCompile(`^\d+([._]\d+)*$`).MatchString(explicitVersion) {
+				return "", errors.FatalError.New("invalid semantic version: " + explicitVersion)
+			}
+
+			prefix := "V" + strings.ReplaceAll(explicitVersion, ".", "_") + "__"
+			version, _ := ParseMigrationVersion(prefix)
+
+			for _, key := range existingKeys {
+				if existingVersion, ok := ParseMigrationVersion(key); ok && semanticVersionRegexp.MatchString(key) && CompareMigrationVersions(existingVersion, version) == 0 {
+					return "", errors.FatalError.New("version " + explicitVersion + " is already used by " + key)
+				}
+			}
+
+			return prefix, nil
+		}
+
+		var latest []int64
+
+		for _, key := range existingKeys {
+			if !semanticVersionRegexp.MatchString(key) {
+				continue
+			}
+
+			version, _ := ParseMigrationVersion(key)
+
+			if latest == nil || CompareMigrationVersions(version, latest) > 0 {
+				latest = version
+			}
+		}
+
+		if latest == nil {
+			return "V1__", nil
+		}
+
+		next := append([]int64{}, latest...)
+		next[len(next)-1]++
+
+		return formatSemanticPrefix(next), nil
+	}
+
+	return "", errors.FatalError.New(
+		"unsupported versioning scheme " + scheme + ", expected one of " + strings.Join(supportedVersioningSchemes, ", "),
+	)
+}
+
+func formatSemanticPrefix(version []int64) string {
+	parts := []string{}
+
+	for _, number := range version {
+		parts = append(parts, strconv.FormatInt(number, 10))
+	}
+
+	return "V" + strings.Join(parts, "_") + "__"
+}
+
+// GetVersioningScheme returns the scheme used to name new migrations.
+func GetVersioningScheme(config types.Config, flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+
+	if value := GetEnv("MONARCH_VERSIONING"); value != "" {
+		return value
+	}
+
+	return config.Versioning
+}
+
+// GetMigrationName strips the version prefix from a migration key.
+func GetMigrationName(key string) string {
+	if match := numericVersionRegexp.FindString(key); match != "" {
+		return strings.TrimPrefix(key, match)
+	}
+
+	if match := semanticVersionRegexp.FindString(key); match != "" {
+		return strings.TrimPrefix(key, match)
+	}
+
+	return key
+}
+
+// FindOutOfOrderMigrations returns the pending migrations whose version is
+// older than the latest applied one. Keys without a version are ignored.
+func FindOutOfOrderMigrations(
+	pendingMigrationObjects []types.MigrationObject,
+	appliedMigrationKeys []string,
+) []types.MigrationObject {
+	latestAppliedKey := ""
+
+	for _, key := range appliedMigrationKeys {
+		if _, ok := ParseMigrationVersion(key); !ok {
+			continue
+		}
+
+		if latestAppliedKey == "" || CompareMigrationKeys(key, latestAppliedKey) > 0 {
+			latestAppliedKey = key
+		}
+	}
+
+	outOfOrderMigrationObjects := []types.MigrationObject{}
+
+	if latestAppliedKey == "" {
+		return outOfOrderMigrationObjects
+	}
+
+	for _, migrationObject := range pendingMigrationObjects {
+		if _, ok := ParseMigrationVersion(migrationObject.Key); !ok {
+			continue
+		}
+
+		if CompareMigrationKeys(migrationObject.Key, latestAppliedKey) < 0 {
+			outOfOrderMigrationObjects = append(outOfOrderMigrationObjects, migrationObject)
+		}
+	}
+
+	return outOfOrderMigrationObjects
+}
+
+// GetMigrationVersionPrefix returns the version prefix of a migration key,
+// including its separator.
+func GetMigrationVersionPrefix(key string) string {
+	return strings.TrimSuffix(key, GetMigrationName(key))
+}
+
+// ParseVersionArg parses a version given on the command line, either as a
+// full migration key, a bare timestamp or sequence number, or a semantic
+// version such as 1.2.
+func ParseVersionArg(value string) ([]int64, bool) {
+	for _, candidate := range []string{
+		value,
+		value + "-",
+		"V" + strings.ReplaceAll(value, ".", "_") + "__",
+	} {
+		if version, ok := ParseMigrationVersion(candidate); ok {
+			return version, true
+		}
+	}
+
+	return nil, false
+}
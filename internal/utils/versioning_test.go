@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestParseMigrationVersion(t *testing.T) {
+	tests := []struct {
+		key  string
+		want []int64
+		ok   bool
+	}{
+		{"20240102030405-users", []int64{20240102030405}, true},
+		{"0007-posts", []int64{7}, true},
+		{"V1__init", []int64{1}, true},
+		{"V1_10_2__emails", []int64{1, 10, 2}, true},
+		{"V1.2__dotted", []int64{1, 2}, true},
+		{"R-views", nil, false},
+		{"users", nil, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := ParseMigrationVersion(tt.key)
+
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseMigrationVersion(%q) = %v, %v, want %v, %v", tt.key, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCompareMigrationKeys(t *testing.T) {
+	keys := []string{"V1_10__j", "V2__k", "V1_2__i", "V1__h", "V1_2_1__l", "V10__m"}
+	want := []string{"V1__h", "V1_2__i", "V1_2_1__l", "V1_10__j", "V2__k", "V10__m"}
+
+	sort.Slice(keys, func(i, j int) bool { return CompareMigrationKeys(keys[i], keys[j]) < 0 })
+
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("semantic keys sorted as %q, want %q", keys, want)
+	}
+
+	// Sequence numbers are compared as numbers, not as strings
+	if CompareMigrationKeys("999-a", "1000-b") >= 0 {
+		t.Error("999-a is not before 1000-b")
+	}
+
+	// Equal versions fall back to the key
+	if CompareMigrationKeys("0001-a", "0001-b") >= 0 {
+		t.Error("0001-a is not before 0001-b")
+	}
+}
+
+func TestGetNextMigrationPrefix(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("EST", -5*60*60))
+
+	tests := []struct {
+		name            string
+		scheme          string
+		existingKeys    []string
+		explicitVersion string
+		want            string
+	}{
+		{"timestamp", "", nil, "", "20240102030405-"},
+		{"utc", "utc", nil, "", "20240102080405-"},
+		{"first sequential", "sequential", nil, "", "0001-"},
+		{"next sequential", "sequential", []string{"0001-a", "0009-b"}, "", "0010-"},
+		{"wide sequential", "sequential", []string{"000042-a"}, "", "000043-"},
+		{"first semantic", "semantic", nil, "", "V1__"},
+		{"next semantic", "semantic", []string{"V1_9__a", "V1_10__b", "V1_2__c"}, "", "V1_11__"},
+		{"explicit semantic", "semantic", []string{"V1__a"}, "2.0", "V2_0__"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, kErr := GetNextMigrationPrefix(tt.scheme, tt.existingKeys, now, tt.explicitVersion)
+
+			if kErr != nil {
+				t.Fatalf("GetNextMigrationPrefix: %v", kErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("GetNextMigrationPrefix = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetNextMigrationPrefixErrors(t *testing.T) {
+	now := time.Now()
+
+	if _, kErr := GetNextMigrationPrefix("semantic", []string{"V1_2__a"}, now, "1.2"); kErr == nil {
+		t.Error("reused semantic version 1.2")
+	}
+
+	if _, kErr := GetNextMigrationPrefix("semantic", nil, now, "1.x"); kErr == nil {
+		t.Error("accepted semantic version 1.x")
+	}
+
+	if _, kErr := GetNextMigrationPrefix("calver", nil, now, ""); kErr == nil {
+		t.Error("accepted versioning scheme calver")
+	}
+}
+
+func TestParseVersionArg(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []int64
+		ok    bool
+	}{
+		{"0003-posts", []int64{3}, true},
+		{"20240102030405", []int64{20240102030405}, true},
+		{"1.2", []int64{1, 2}, true},
+		{"V1_2__init", []int64{1, 2}, true},
+		{"0", []int64{0}, true},
+		{"latest", nil, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := ParseVersionArg(tt.value)
+
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseVersionArg(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
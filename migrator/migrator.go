@@ -0,0 +1,455 @@
+// Package migrator runs monarch migrations from Go code instead of the CLI.
+// Migrations are read from an fs.FS, typically an embed.FS, and tracked in
+// the same migrations table the CLI uses.
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+
+	"github.com/cmseguin/khata"
+	"github.com/cmseguin/monarch/internal/errors"
+	"github.com/cmseguin/monarch/internal/types"
+	"github.com/cmseguin/monarch/internal/utils"
+)
+
+type Migrator struct {
+	db     *sql.DB
+	fsys   fs.FS
+	vars   map[string]string
+	filter types.MigrationFilter
+}
+
+type Option func(*Migrator)
+
+// WithVars sets the template variables the migrations are rendered with.
+func WithVars(vars map[string]string) Option {
+	return func(m *Migrator) {
+		m.vars = vars
+	}
+}
+
+// WithEnv selects the migrations restricted to an environment.
+func WithEnv(env string) Option {
+	return func(m *Migrator) {
+		m.filter.Env = env
+	}
+}
+
+// WithTags only runs the migrations carrying one of the tags.
+func WithTags(tags ...string) Option {
+	return func(m *Migrator) {
+		m.filter.Tags = tags
+	}
+}
+
+// New creates a migrator for the migrations of fsys. fsys is either the
+// migrations directory itself or a file system containing it.
+func New(db *sql.DB, fsys fs.FS, options ...Option) *Migrator {
+	if info, err := fs.Stat(fsys, "migrations"); err == nil && info.IsDir() {
+		if sub, err := fs.Sub(fsys, "migrations"); err == nil {
+			fsys = sub
+		}
+	}
+
+	m := &Migrator{db: db, fsys: fsys, vars: map[string]string{}}
+
+	for _, option := range options {
+		option(m)
+	}
+
+	return m
+}
+
+// Up runs every pending migration, then the repeatable migrations that
+// changed. Cancelling ctx interrupts the running migration and rolls back its
+// transaction.
+func (m *Migrator) Up(ctx context.Context) error {
+	return toError(m.up(ctx, nil))
+}
+
+// UpTo runs the pending migrations up to and including a version.
+// Repeatable migrations only run with Up.
+func (m *Migrator) UpTo(ctx context.Context, version string) error {
+	target, ok := utils.ParseVersionArg(version)
+
+	if !ok {
+		return toError(errors.FatalError.New("invalid version: " + version))
+	}
+
+	return toError(m.up(ctx, target))
+}
+
+// DownTo rolls back the applied migrations newer than a version. Rolling
+// back to version 0 reverts every migration.
+func (m *Migrator) DownTo(ctx context.Context, version string) error {
+	target, ok := utils.ParseVersionArg(version)
+
+	if !ok {
+		return toError(errors.FatalError.New("invalid version: " + version))
+	}
+
+	return toError(m.down(ctx, target))
+}
+
+// MigrateTo brings the database to a version, running or rolling back
+// migrations as needed.
+func (m *Migrator) MigrateTo(ctx context.Context, version string) error {
+	if err := m.UpTo(ctx, version); err != nil {
+		return err
+	}
+
+	return m.DownTo(ctx, version)
+}
+
+// Applied returns the keys of the applied migrations in order.
+func (m *Migrator) Applied() ([]string, error) {
+	migrationsFromDb, kErr := m.getMigrationsFromDatabase()
+
+	if kErr != nil {
+		return nil, toError(kErr)
+	}
+
+	sortedMigrationObjects, kErr := m.sortedMigrationObjects(utils.GetUpMigrationObjectsFromFS)
+
+	if kErr != nil {
+		return nil, toError(kErr)
+	}
+
+	appliedMigrationKeys := []string{}
+
+	for _, migrationObject := range sortedMigrationObjects {
+		if migrationsFromDb[migrationObject.Key].IsApplied {
+			appliedMigrationKeys = append(appliedMigrationKeys, migrationObject.Key)
+		}
+	}
+
+	return appliedMigrationKeys, nil
+}
+
+// Pending returns the keys of the migrations selected by the migrator that
+// are not applied, in order.
+func (m *Migrator) Pending() ([]string, error) {
+	migrationsFromDb, kErr := m.getMigrationsFromDatabase()
+
+	if kErr != nil {
+		return nil, toError(kErr)
+	}
+
+	sortedMigrationObjects, kErr := m.sortedMigrationObjects(utils.GetUpMigrationObjectsFromFS)
+
+	if kErr != nil {
+		return nil, toError(kErr)
+	}
+
+	pendingMigrationKeys := []string{}
+
+	for _, migrationObject := range sortedMigrationObjects {
+		if !migrationsFromDb[migrationObject.Key].IsApplied && utils.IsMigrationSelected(migrationObject.Directives, m.filter) {
+			pendingMigrationKeys = append(pendingMigrationKeys, migrationObject.Key)
+		}
+	}
+
+	return pendingMigrationKeys, nil
+}
+
+func (m *Migrator) up(ctx context.Context, target []int64) *khata.Khata {
+	sortedMigrationObjects, kErr := m.sortedMigrationObjects(utils.GetUpMigrationObjectsFromFS)
+
+	if kErr != nil {
+		return kErr
+	}
+
+	migrationsFromDb, kErr := m.getMigrationsFromDatabase()
+
+	if kErr != nil {
+		return kErr
+	}
+
+	kErr = m.checkDirtyMigrations(migrationsFromDb)
+
+	if kErr != nil {
+		return kErr
+	}
+
+	appliedMigrationKeys := []string{}
+	for _, migration := range migrationsFromDb {
+		if migration.IsApplied {
+			appliedMigrationKeys = append(appliedMigrationKeys, migration.Key)
+		}
+	}
+
+	// Keep other monarch processes from migrating the database meanwhile
+	release, kErr := utils.LockMigrations(ctx, m.db, appliedMigrationKeys)
+
+	if kErr != nil {
+		return kErr
+	}
+
+	defer release()
+
+	// Every migration applied by this run belongs to the same batch
+	batch, kErr := utils.GetNextMigrationBatch(m.db)
+
+	if kErr != nil {
+		return kErr.Explain("Error getting the migration batch")
+	}
+
+	previousMigrationKeys := []string{}
+
+	for _, migrationObject := range sortedMigrationObjects {
+		if target != nil && !isVersionAtMost(migrationObject.Key, target) {
+			break
+		}
+
+		if migrationsFromDb[migrationObject.Key].IsApplied || !utils.IsMigrationSelected(migrationObject.Directives, m.filter) {
+			continue
+		}
+
+		isSatisfied, kErr := utils.IsBaselineSatisfied(migrationObject, appliedMigrationKeys)
+
+		if kErr != nil {
+			return kErr.Explain("Error checking squashed migrations")
+		}
+
+		// Baselines standing for migrations that were already applied are only recorded
+		if !isSatisfied {
+			kErr = utils.CheckMigrationRequirements(
+				migrationObject,
+				utils.AddSquashedMigrationKeys(sortedMigrationObjects, appliedMigrationKeys),
+				utils.AddSquashedMigrationKeys(sortedMigrationObjects, previousMigrationKeys),
+			)
+
+			if kErr != nil {
+				return kErr.Explain("Error checking migration requirements")
+			}
+
+			kErr = m.runMigration(ctx, migrationObject, migrationsFromDb[migrationObject.Key])
+
+			if kErr != nil {
+				return kErr
+			}
+		} else if migrationsFromDb[migrationObject.Key].Key != migrationObject.Key {
+			kErr = utils.CreateMigrationEntry(m.db, migrationObject.Key)
+
+			if kErr != nil {
+				return kErr.Explainf("Error creating migration entry: %s", migrationObject.Key)
+			}
+		}
+
+		kErr = m.recordMigration(migrationObject, batch)
+
+		if kErr != nil {
+			return kErr
+		}
+
+		previousMigrationKeys = append(previousMigrationKeys, migrationObject.Key)
+	}
+
+	if target != nil {
+		return nil
+	}
+
+	repeatableMigrationObjects := []types.MigrationObject{}
+
+	kErr = utils.GetRepeatableMigrationObjectsFromFS(m.fsys, m.vars, &repeatableMigrationObjects)
+
+	if kErr != nil {
+		return kErr.Explain("Error getting repeatable migration objects")
+	}
+
+	repeatableMigrationObjects, _ = utils.SplitMigrationsByFilter(utils.SortMigrationObjects(repeatableMigrationObjects), m.filter)
+
+	for _, migrationObject := range utils.FilterStaleRepeatableMigrations(repeatableMigrationObjects, migrationsFromDb) {
+		kErr = m.runMigration(ctx, migrationObject, migrationsFromDb[migrationObject.Key])
+
+		if kErr != nil {
+			return kErr
+		}
+
+		kErr = m.recordMigration(migrationObject, batch)
+
+		if kErr != nil {
+			return kErr
+		}
+
+		kErr = utils.SetMigrationChecksum(m.db, migrationObject.Key, migrationObject.Checksum)
+
+		if kErr != nil {
+			return kErr.Explainf("Error updating the checksum of migration: %s", migrationObject.Key)
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) down(ctx context.Context, target []int64) *khata.Khata {
+	migrationsFromDb, kErr := m.getMigrationsFromDatabase()
+
+	if kErr != nil {
+		return kErr
+	}
+
+	sortedMigrationObjects, kErr := m.sortedMigrationObjects(utils.GetUpMigrationObjectsFromFS)
+
+	if kErr != nil {
+		return kErr
+	}
+
+	kErr = m.checkDirtyMigrations(migrationsFromDb)
+
+	if kErr != nil {
+		return kErr
+	}
+
+	downMigrationObjects, kErr := m.sortedMigrationObjects(utils.GetDownMigrationObjectsFromFS)
+
+	if kErr != nil {
+		return kErr
+	}
+
+	appliedMigrationKeys := []string{}
+	for _, migration := range migrationsFromDb {
+		if migration.IsApplied {
+			appliedMigrationKeys = append(appliedMigrationKeys, migration.Key)
+		}
+	}
+
+	// Keep other monarch processes from migrating the database meanwhile
+	release, kErr := utils.LockMigrations(ctx, m.db, appliedMigrationKeys)
+
+	if kErr != nil {
+		return kErr
+	}
+
+	defer release()
+
+	downMigrationObjectsMap := map[string]types.MigrationObject{}
+	for _, downMigrationObject := range downMigrationObjects {
+		downMigrationObjectsMap[downMigrationObject.Key] = downMigrationObject
+	}
+
+	for _, migrationObject := range utils.ReverseMigrationObjects(sortedMigrationObjects) {
+		if isVersionAtMost(migrationObject.Key, target) {
+			break
+		}
+
+		if !migrationsFromDb[migrationObject.Key].IsApplied {
+			continue
+		}
+
+		if migrationObject.Directives.Irreversible {
+			return errors.FatalError.New("migration " + migrationObject.Key + " is irreversible")
+		}
+
+		downMigrationObject, ok := downMigrationObjectsMap[migrationObject.Key]
+
+		if !ok {
+			return errors.FatalError.New("migration " + migrationObject.Key + " has no down migration")
+		}
+
+		kErr = m.runMigration(ctx, downMigrationObject, migrationsFromDb[migrationObject.Key])
+
+		if kErr != nil {
+			return kErr
+		}
+
+		kErr = utils.RollbackMigration(m.db, migrationObject.Key)
+
+		if kErr != nil {
+			return kErr.Explainf("Error updating the status of migration: %s", migrationObject.Key)
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) runMigration(ctx context.Context, migrationObject types.MigrationObject, migration types.Migration) *khata.Khata {
+	fileContent, kErr := utils.GetRenderedMigrationContentFromFS(m.fsys, migrationObject.File, m.vars)
+
+	if kErr != nil {
+		return kErr.Explainf("Error getting migration content: %s", migrationObject.File)
+	}
+
+	return utils.RunTrackedMigration(ctx, m.db, migrationObject, fileContent, migration)
+}
+
+func (m *Migrator) recordMigration(migrationObject types.MigrationObject, batch int) *khata.Khata {
+	kErr := utils.ApplyMigration(m.db, migrationObject.Key, utils.FormatMigrationFilter(m.filter), batch)
+
+	if kErr != nil {
+		return kErr.Explainf("Error updating the status of migration: %s", migrationObject.Key)
+	}
+
+	return nil
+}
+
+func (m *Migrator) checkDirtyMigrations(migrationsFromDb map[string]types.Migration) *khata.Khata {
+	migrations := []types.Migration{}
+	for _, migration := range migrationsFromDb {
+		migrations = append(migrations, migration)
+	}
+
+	return utils.CheckDirtyMigrations(migrations)
+}
+
+// getMigrationsFromDatabase creates the migrations table when needed and
+// returns its rows by key.
+func (m *Migrator) getMigrationsFromDatabase() (map[string]types.Migration, *khata.Khata) {
+	kErr := utils.CreateMigrationTable(m.db)
+
+	if kErr != nil {
+		return nil, kErr.Explain("Error creating the migrations table")
+	}
+
+	migrationsFromDb, kErr := utils.GetAllMigrationsFromDatabase(m.db)
+
+	if kErr != nil {
+		return nil, kErr.Explain("Error getting all migrations from database")
+	}
+
+	migrationsFromDbMap := map[string]types.Migration{}
+	for _, migration := range migrationsFromDb {
+		migrationsFromDbMap[migration.Key] = migration
+	}
+
+	return migrationsFromDbMap, nil
+}
+
+func (m *Migrator) sortedMigrationObjects(
+	load func(fs.FS, *[]types.MigrationObject) *khata.Khata,
+) ([]types.MigrationObject, *khata.Khata) {
+	migrationObjects := []types.MigrationObject{}
+
+	kErr := load(m.fsys, &migrationObjects)
+
+	if kErr != nil {
+		return nil, kErr.Explain("Error getting migration objects")
+	}
+
+	return utils.SortMigrationObjects(migrationObjects), nil
+}
+
+func isVersionAtMost(key string, target []int64) bool {
+	version, ok := utils.ParseMigrationVersion(key)
+
+	return ok && utils.CompareMigrationVersions(version, target) <= 0
+}
+
+// toError converts a khata error into a plain error carrying its latest
+// explanation, without turning a nil khata into a non-nil error.
+func toError(kErr *khata.Khata) error {
+	if kErr == nil {
+		return nil
+	}
+
+	explanations := kErr.Explanations()
+
+	if len(explanations) == 0 {
+		return kErr
+	}
+
+	return fmt.Errorf("%s: %w", explanations[len(explanations)-1].Message(), kErr)
+}
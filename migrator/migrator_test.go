@@ -0,0 +1,173 @@
+package migrator_test
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/cmseguin/monarch/internal/utils"
+	"github.com/cmseguin/monarch/migrator"
+)
+
+var migrations = fstest.MapFS{
+	"migrations/0001-users.up.sql":     {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);")},
+	"migrations/0001-users.down.sql":   {Data: []byte("DROP TABLE users;")},
+	"migrations/0002-posts.up.sql":     {Data: []byte("CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER);")},
+	"migrations/0002-posts.down.sql":   {Data: []byte("DROP TABLE posts;")},
+	"migrations/0003-emails.up.sql":    {Data: []byte("ALTER TABLE users ADD COLUMN email TEXT;")},
+	"migrations/0003-emails.down.sql":  {Data: []byte("ALTER TABLE users DROP COLUMN email;")},
+	"migrations/0004-staging.up.sql":   {Data: []byte("-- monarch:env=staging\nCREATE TABLE fixtures (id INTEGER);")},
+	"migrations/0004-staging.down.sql": {Data: []byte("DROP TABLE fixtures;")},
+}
+
+func newMigrator(t *testing.T, options ...migrator.Option) (*migrator.Migrator, *sql.DB) {
+	t.Helper()
+
+	db, kErr := utils.OpenScratchDatabase("sqlite", "")
+
+	if kErr != nil {
+		t.Fatalf("open: %v", kErr)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	return migrator.New(db, migrations, options...), db
+}
+
+func assertKeys(t *testing.T, name string, get func() ([]string, error), want ...string) {
+	t.Helper()
+
+	got, err := get()
+
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+
+	if want == nil {
+		want = []string{}
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s = %q, want %q", name, got, want)
+	}
+}
+
+func assertTable(t *testing.T, db *sql.DB, table string, exists bool) {
+	t.Helper()
+
+	var count int
+
+	if err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&count); err != nil {
+		t.Fatalf("read schema: %v", err)
+	}
+
+	if (count > 0) != exists {
+		t.Fatalf("table %s exists = %v, want %v", table, count > 0, exists)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	m, db := newMigrator(t)
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	// The staging migration is only run with its environment
+	assertKeys(t, "applied", m.Applied, "0001-users", "0002-posts", "0003-emails")
+	assertKeys(t, "pending", m.Pending)
+	assertTable(t, db, "posts", true)
+	assertTable(t, db, "fixtures", false)
+
+	if err := m.DownTo(ctx, "0001"); err != nil {
+		t.Fatalf("down to 0001: %v", err)
+	}
+
+	assertKeys(t, "applied", m.Applied, "0001-users")
+	assertKeys(t, "pending", m.Pending, "0002-posts", "0003-emails")
+	assertTable(t, db, "posts", false)
+
+	if err := m.UpTo(ctx, "0002"); err != nil {
+		t.Fatalf("up to 0002: %v", err)
+	}
+
+	assertKeys(t, "applied", m.Applied, "0001-users", "0002-posts")
+	assertTable(t, db, "posts", true)
+
+	if err := m.MigrateTo(ctx, "0003"); err != nil {
+		t.Fatalf("migrate to 0003: %v", err)
+	}
+
+	assertKeys(t, "applied", m.Applied, "0001-users", "0002-posts", "0003-emails")
+
+	if err := m.MigrateTo(ctx, "0"); err != nil {
+		t.Fatalf("migrate to 0: %v", err)
+	}
+
+	assertKeys(t, "applied", m.Applied)
+	assertTable(t, db, "users", false)
+
+	// The migrations run again once rolled back
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("up again: %v", err)
+	}
+
+	assertKeys(t, "applied", m.Applied, "0001-users", "0002-posts", "0003-emails")
+}
+
+func TestWithEnv(t *testing.T) {
+	m, db := newMigrator(t, migrator.WithEnv("staging"))
+
+	if err := m.Up(context.Background()); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	assertKeys(t, "applied", m.Applied, "0001-users", "0002-posts", "0003-emails", "0004-staging")
+	assertTable(t, db, "fixtures", true)
+}
+
+func TestInvalidVersion(t *testing.T) {
+	m, _ := newMigrator(t)
+
+	if err := m.MigrateTo(context.Background(), "latest"); err == nil {
+		t.Fatal("migrated to an invalid version")
+	}
+}
+
+func TestRepeatableVars(t *testing.T) {
+	ctx := context.Background()
+
+	db, kErr := utils.OpenScratchDatabase("sqlite", "")
+
+	if kErr != nil {
+		t.Fatalf("open: %v", kErr)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	fsys := fstest.MapFS{
+		"R-answer.sql": {Data: []byte("-- monarch:template\nDROP VIEW IF EXISTS answer;\nCREATE VIEW answer AS SELECT ${ANSWER} AS value;")},
+	}
+
+	for _, value := range []string{"41", "42"} {
+		m := migrator.New(db, fsys, migrator.WithVars(map[string]string{"ANSWER": value}))
+
+		if err := m.Up(ctx); err != nil {
+			t.Fatalf("up with %s: %v", value, err)
+		}
+
+		var got string
+
+		if err := db.QueryRow("SELECT value FROM answer").Scan(&got); err != nil {
+			t.Fatalf("read view: %v", err)
+		}
+
+		// A new value of a variable re-applies the repeatable migration
+		if got != value {
+			t.Fatalf("answer = %s, want %s", got, value)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Package monarchtest provides helpers for Go tests that need a database
+// migrated with monarch migrations.
+package monarchtest
+
+import (
+	"context"
+	"database/sql"
+	"io/fs"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cmseguin/monarch/internal/utils"
+	"github.com/cmseguin/monarch/migrator"
+)
+
+// The migrator of every database opened by NewSQLite, so MigrateTo and the
+// assertions only need the database
+var migrators sync.Map
+
+// NewSQLite returns an in-memory SQLite database with every migration of fsys
+// applied. The database is closed when the test ends.
+func NewSQLite(t testing.TB, fsys fs.FS, options ...migrator.Option) *sql.DB {
+	t.Helper()
+
+	db, kErr := utils.OpenScratchDatabase("sqlite", "")
+
+	if kErr != nil {
+		t.Fatalf("monarchtest: could not open database: %v", kErr)
+	}
+
+	m := migrator.New(db, fsys, options...)
+	migrators.Store(db, m)
+
+	t.Cleanup(func() {
+		migrators.Delete(db)
+		db.Close()
+	})
+
+	if err := m.Up(context.Background()); err != nil {
+		t.Fatalf("monarchtest: could not run migrations: %v", err)
+	}
+
+	return db
+}
+
+// MigrateTo brings a database opened by NewSQLite to a version, rolling back
+// the newer migrations or running the missing ones. It is meant to test data
+// migrations: migrate to the version before, insert data, then migrate to the
+// version under test.
+func MigrateTo(t testing.TB, db *sql.DB, version string) {
+	t.Helper()
+
+	if err := getMigrator(t, db).MigrateTo(context.Background(), version); err != nil {
+		t.Fatalf("monarchtest: could not migrate to %s: %v", version, err)
+	}
+}
+
+// AppliedMigrations returns the keys of the migrations applied to a database
+// opened by NewSQLite, in order.
+func AppliedMigrations(t testing.TB, db *sql.DB) []string {
+	t.Helper()
+
+	appliedMigrationKeys, err := getMigrator(t, db).Applied()
+
+	if err != nil {
+		t.Fatalf("monarchtest: could not get applied migrations: %v", err)
+	}
+
+	return appliedMigrationKeys
+}
+
+// PendingMigrations returns the keys of the migrations not applied to a
+// database opened by NewSQLite, in order.
+func PendingMigrations(t testing.TB, db *sql.DB) []string {
+	t.Helper()
+
+	pendingMigrationKeys, err := getMigrator(t, db).Pending()
+
+	if err != nil {
+		t.Fatalf("monarchtest: could not get pending migrations: %v", err)
+	}
+
+	return pendingMigrationKeys
+}
+
+// AssertApplied fails the test unless every migration is applied. Migrations
+// are given by key or by name without their version prefix.
+func AssertApplied(t testing.TB, db *sql.DB, migrations ...string) {
+	t.Helper()
+
+	appliedMigrationKeys := AppliedMigrations(t, db)
+
+	for _, migration := range migrations {
+		if !containsMigration(appliedMigrationKeys, migration) {
+			t.Errorf("monarchtest: migration %s is not applied, applied: %s", migration, strings.Join(appliedMigrationKeys, ", "))
+		}
+	}
+}
+
+// AssertPending fails the test unless every migration is pending. Migrations
+// are given by key or by name without their version prefix.
+func AssertPending(t testing.TB, db *sql.DB, migrations ...string) {
+	t.Helper()
+
+	pendingMigrationKeys := PendingMigrations(t, db)
+
+	for _, migration := range migrations {
+		if !containsMigration(pendingMigrationKeys, migration) {
+			t.Errorf("monarchtest: migration %s is not pending, pending: %s", migration, strings.Join(pendingMigrationKeys, ", "))
+		}
+	}
+}
+
+// AssertAppliedExactly fails the test unless the applied migrations are
+// exactly the given ones, in order.
+func AssertAppliedExactly(t testing.TB, db *sql.DB, migrations ...string) {
+	t.Helper()
+
+	appliedMigrationKeys := AppliedMigrations(t, db)
+
+	if len(appliedMigrationKeys) != len(migrations) {
+		t.Errorf("monarchtest: expected applied migrations %s, got %s", strings.Join(migrations, ", "), strings.Join(appliedMigrationKeys, ", "))
+		return
+	}
+
+	for i, migration := range migrations {
+		if !matchesMigration(appliedMigrationKeys[i], migration) {
+			t.Errorf("monarchtest: expected applied migrations %s, got %s", strings.Join(migrations, ", "), strings.Join(appliedMigrationKeys, ", "))
+			return
+		}
+	}
+}
+
+func getMigrator(t testing.TB, db *sql.DB) *migrator.Migrator {
+	t.Helper()
+
+	m, ok := migrators.Load(db)
+
+	if !ok {
+		t.Fatalf("monarchtest: database was not opened by monarchtest.NewSQLite")
+	}
+
+	return m.(*migrator.Migrator)
+}
+
+func containsMigration(keys []string, migration string) bool {
+	return utils.FindIndexInString(keys, func(key string, _ int) bool {
+		return matchesMigration(key, migration)
+	}) != -1
+}
+
+func matchesMigration(key string, migration string) bool {
+	return key == migration || utils.GetMigrationName(key) == migration
+}
@@ -0,0 +1,73 @@
+package monarchtest_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/cmseguin/monarch/monarchtest"
+)
+
+var migrations = fstest.MapFS{
+	"0001-users.up.sql":       {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);")},
+	"0001-users.down.sql":     {Data: []byte("DROP TABLE users;")},
+	"0002-full-name.up.sql":   {Data: []byte("ALTER TABLE users ADD COLUMN full_name TEXT;\nUPDATE users SET full_name = upper(name);")},
+	"0002-full-name.down.sql": {Data: []byte("ALTER TABLE users DROP COLUMN full_name;")},
+}
+
+func TestNewSQLite(t *testing.T) {
+	db := monarchtest.NewSQLite(t, migrations)
+
+	monarchtest.AssertAppliedExactly(t, db, "0001-users", "full-name")
+
+	if pending := monarchtest.PendingMigrations(t, db); len(pending) != 0 {
+		t.Fatalf("pending = %q, want none", pending)
+	}
+}
+
+func TestMigrateTo(t *testing.T) {
+	db := monarchtest.NewSQLite(t, migrations)
+
+	monarchtest.MigrateTo(t, db, "0001")
+	monarchtest.AssertApplied(t, db, "users")
+	monarchtest.AssertPending(t, db, "0002-full-name")
+
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('ada')"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	// The data migration fills in the new column for the existing rows
+	monarchtest.MigrateTo(t, db, "0002")
+	monarchtest.AssertAppliedExactly(t, db, "users", "full-name")
+
+	var fullName string
+
+	if err := db.QueryRow("SELECT full_name FROM users WHERE name = 'ada'").Scan(&fullName); err != nil {
+		t.Fatalf("select: %v", err)
+	}
+
+	if fullName != "ADA" {
+		t.Fatalf("full_name = %q, want %q", fullName, "ADA")
+	}
+}
+
+// recordingTB records the failures of an assertion instead of failing the test
+type recordingTB struct {
+	testing.TB
+	failed bool
+}
+
+func (r *recordingTB) Errorf(format string, args ...any) {
+	r.failed = true
+}
+
+func TestAssertPendingUnknownMigration(t *testing.T) {
+	db := monarchtest.NewSQLite(t, migrations)
+	monarchtest.MigrateTo(t, db, "0001")
+
+	recorder := &recordingTB{TB: t}
+	monarchtest.AssertPending(recorder, db, "full-nmae")
+
+	if !recorder.failed {
+		t.Fatal("AssertPending passed for a migration that does not exist")
+	}
+}